@@ -0,0 +1,247 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return `closed`
+	case BreakerOpen:
+		return `open`
+	case BreakerHalfOpen:
+		return `half-open`
+	}
+	return `unknown`
+}
+
+// ErrBreakerOpen is wrapped in the NonRetriableError returned for calls rejected by an open
+// Breaker.
+var ErrBreakerOpen = errors.New(`circuit breaker is open`)
+
+// Breaker is a circuit breaker for calls to a single dependency. Wrap a RetryFunc or
+// CancellableFunc with it and pass the result to Retry.
+//
+// A closed Breaker lets calls through and trips open after ConsecutiveFailures failures
+// in a row, or once the fraction of failed calls within Window reaches FailureRate over at
+// least MinCalls calls. An open Breaker rejects calls with a NonRetriableError wrapping
+// ErrBreakerOpen, so retry loops give up immediately. After CoolDown it turns half-open
+// and lets up to HalfOpenProbes calls through at a time; that many successes close it
+// again and any failure reopens it.
+//
+// A call fails when it returns a retriable ClientError. Non-retriable errors are the
+// caller's problem rather than the dependency's and count as successes. Calls abandoned
+// because their context is done are not counted at all.
+//
+// The zero value never trips. A Breaker must not be copied after first use.
+type Breaker struct {
+	ConsecutiveFailures uint
+	FailureRate         float64
+	MinCalls            uint
+	Window              time.Duration
+	CoolDown            time.Duration
+	// HalfOpenProbes of zero allows a single probe.
+	HalfOpenProbes uint
+	// OnStateChange, if set, is called after every state transition.
+	OnStateChange func(from, to BreakerState)
+	// Clock defaults to SystemClock.
+	Clock Clock
+
+	mu          sync.Mutex
+	state       BreakerState
+	consecutive uint
+	openedAt    time.Time
+	probes      uint
+	successes   uint
+	// calls and failures within Window
+	calls rollingCounts
+}
+
+// State returns the current state, moving an open Breaker whose cool-down has elapsed to
+// half-open.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	from := b.state
+	to := b.cool()
+	b.mu.Unlock()
+	b.notify(from, to)
+	return to
+}
+
+// Wrap returns a RetryFunc that calls f through the Breaker.
+func (b *Breaker) Wrap(f RetryFunc) RetryFunc {
+	return func() (interface{}, ClientError) {
+		return b.WrapContext(func(context.Context) (interface{}, ClientError) {
+			return f()
+		})(context.Background())
+	}
+}
+
+// WrapContext returns a CancellableFunc that calls f through the Breaker.
+func (b *Breaker) WrapContext(f CancellableFunc) CancellableFunc {
+	return func(ctx context.Context) (interface{}, ClientError) {
+		probe, ok := b.acquire()
+		if !ok {
+			return nil, NonRetriableError{E: ErrBreakerOpen}
+		}
+		result, err := f(ctx)
+		if err != nil && ctx.Err() != nil {
+			b.release(probe)
+		} else {
+			b.record(probe, err != nil && err.IsRetriable())
+		}
+		return result, err
+	}
+}
+
+// acquire reports whether a call is a half-open probe and whether it may proceed.
+func (b *Breaker) acquire() (bool, bool) {
+	b.mu.Lock()
+	from := b.state
+	to := b.cool()
+	probe, ok := false, true
+	switch to {
+	case BreakerOpen:
+		ok = false
+	case BreakerHalfOpen:
+		if b.probes < b.maxProbes() {
+			b.probes++
+			probe = true
+		} else {
+			ok = false
+		}
+	}
+	b.mu.Unlock()
+	b.notify(from, to)
+	return probe, ok
+}
+
+func (b *Breaker) release(probe bool) {
+	if !probe {
+		return
+	}
+	b.mu.Lock()
+	if b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+	b.mu.Unlock()
+}
+
+func (b *Breaker) record(probe bool, failed bool) {
+	b.mu.Lock()
+	from := b.state
+	now := b.clock().Now()
+	if probe {
+		if b.state == BreakerHalfOpen {
+			b.probes--
+			if failed {
+				b.trip(now)
+			} else if b.successes++; b.successes >= b.maxProbes() {
+				b.reset()
+			}
+		}
+	} else if b.state == BreakerClosed {
+		b.count(now, failed)
+		if failed {
+			b.consecutive++
+		} else {
+			b.consecutive = 0
+		}
+		if b.tripped(now) {
+			b.trip(now)
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+// cool moves an open Breaker whose cool-down has elapsed to half-open and returns the
+// resulting state. b.mu must be held.
+func (b *Breaker) cool() BreakerState {
+	if b.state == BreakerOpen && !b.clock().Now().Before(b.openedAt.Add(b.CoolDown)) {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+		b.successes = 0
+	}
+	return b.state
+}
+
+func (b *Breaker) trip(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+}
+
+func (b *Breaker) reset() {
+	b.state = BreakerClosed
+	b.consecutive = 0
+	b.calls.reset()
+}
+
+func (b *Breaker) tripped(now time.Time) bool {
+	if b.ConsecutiveFailures > 0 && b.consecutive >= b.ConsecutiveFailures {
+		return true
+	}
+	if b.FailureRate <= 0 || b.Window <= 0 {
+		return false
+	}
+	calls, failures := b.calls.sum(now, b.Window)
+	return calls > 0 && calls >= b.MinCalls && float64(failures)/float64(calls) >= b.FailureRate
+}
+
+func (b *Breaker) count(now time.Time, failed bool) {
+	if b.Window <= 0 {
+		return
+	}
+	if failed {
+		b.calls.add(now, b.Window, 1, 1)
+	} else {
+		b.calls.add(now, b.Window, 1, 0)
+	}
+}
+
+func (b *Breaker) maxProbes() uint {
+	if b.HalfOpenProbes == 0 {
+		return 1
+	}
+	return b.HalfOpenProbes
+}
+
+func (b *Breaker) clock() Clock {
+	if b.Clock == nil {
+		return SystemClock
+	}
+	return b.Clock
+}
+
+func (b *Breaker) notify(from, to BreakerState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
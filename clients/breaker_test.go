@@ -0,0 +1,153 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// manualClock reads a time set by the test.
+type manualClock struct {
+	now time.Time
+}
+
+func (m *manualClock) Now() time.Time                         { return m.now }
+func (m *manualClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (m *manualClock) NewTimer(d time.Duration) Timer         { return SystemClock.NewTimer(d) }
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	clock := &manualClock{}
+	var transitions []BreakerState
+	b := &Breaker{
+		ConsecutiveFailures: 2,
+		CoolDown:            time.Duration(1) * time.Second,
+		Clock:               clock,
+		OnStateChange: func(from, to BreakerState) {
+			transitions = append(transitions, to)
+		},
+	}
+	fail := true
+	calls := 0
+	f := b.Wrap(func() (interface{}, ClientError) {
+		calls++
+		if fail {
+			return nil, RetriableError{E: errors.New(`retriable`)}
+		}
+		return calls, nil
+	})
+
+	f()
+	if b.State() != BreakerClosed {
+		t.Fatal(`Tripped before reaching the consecutive failure limit`)
+	}
+	f()
+	if b.State() != BreakerOpen {
+		t.Fatal(`Failed to trip at the consecutive failure limit`)
+	}
+
+	_, e := f()
+	if calls != 2 {
+		t.Fatal(`Open breaker let a call through`)
+	}
+	if e == nil || e.IsRetriable() || e.Cause() != ErrBreakerOpen {
+		t.Fatal(`Open breaker did not short-circuit with a NonRetriableError`)
+	}
+
+	// A failed probe reopens the breaker
+	clock.now = clock.now.Add(b.CoolDown)
+	if b.State() != BreakerHalfOpen {
+		t.Fatal(`Failed to turn half-open after the cool-down`)
+	}
+	f()
+	if b.State() != BreakerOpen {
+		t.Fatal(`Failed probe did not reopen the breaker`)
+	}
+
+	// A successful probe closes it
+	clock.now = clock.now.Add(b.CoolDown)
+	fail = false
+	if r, e := f(); e != nil || r != 4 {
+		t.Fatal(`Half-open breaker did not let the probe through`)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatal(`Successful probe did not close the breaker`)
+	}
+
+	expected := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(transitions) != len(expected) {
+		t.Fatalf(`Expected transitions %v, got %v`, expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Fatalf(`Expected transitions %v, got %v`, expected, transitions)
+		}
+	}
+}
+
+func TestBreakerFailureRate(t *testing.T) {
+	clock := &manualClock{now: time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	b := &Breaker{
+		FailureRate: 0.5,
+		MinCalls:    4,
+		Window:      time.Duration(10) * time.Second,
+		CoolDown:    time.Duration(1) * time.Second,
+		Clock:       clock,
+	}
+	ok := b.Wrap(func() (interface{}, ClientError) { return nil, nil })
+	bad := b.Wrap(func() (interface{}, ClientError) {
+		return nil, RetriableError{E: errors.New(`retriable`)}
+	})
+	userError := b.Wrap(func() (interface{}, ClientError) {
+		return nil, NonRetriableError{E: errors.New(`nonretriable`)}
+	})
+
+	bad()
+	userError()
+	bad()
+	if b.State() != BreakerClosed {
+		t.Fatal(`Tripped before MinCalls calls`)
+	}
+
+	// Calls that have aged out of the window no longer count
+	clock.now = clock.now.Add(b.Window)
+	ok()
+	bad()
+	ok()
+	if b.State() != BreakerClosed {
+		t.Fatal(`Tripped on calls outside the window`)
+	}
+	bad()
+	if b.State() != BreakerOpen {
+		t.Fatal(`Failed to trip at the failure rate`)
+	}
+}
+
+func TestBreakerRetry(t *testing.T) {
+	b := &Breaker{ConsecutiveFailures: 3, CoolDown: time.Duration(1) * time.Hour}
+	calls := 0
+	f := b.Wrap(func() (interface{}, ClientError) {
+		calls++
+		return nil, RetriableError{E: errors.New(`retriable`)}
+	})
+	_, e := Retry(f, &JitteredBackoff{TTL: time.Duration(1) * time.Minute, Bof: NoBackoff, Jf: NoJitter})
+	if calls != 3 {
+		t.Fatalf(`Expected the breaker to stop the retry loop after 3 calls, got %v`, calls)
+	}
+	if !errors.Is(e, ErrBreakerOpen) {
+		t.Fatalf(`Expected ErrBreakerOpen, got %v`, e)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RetryBudget limits the retries made by every caller of a dependency to a fraction of the
+// calls they make, so that independent retry loops cannot amplify an outage into a retry
+// storm. Share one RetryBudget per dependency and gate each retry loop on it with
+// Budgeted.
+//
+// Over the trailing Window, retries are allowed while they number fewer than Ratio times
+// the first attempts plus MinPerSecond for every second of the window. The floor lets
+// dependencies with little traffic still retry.
+//
+// A RetryBudget is safe for concurrent use and must not be copied after first use.
+type RetryBudget struct {
+	Ratio        float64
+	MinPerSecond float64
+	// Window defaults to ten seconds.
+	Window time.Duration
+	// Clock defaults to SystemClock.
+	Clock Clock
+
+	mu sync.Mutex
+	// first attempts and retries within Window
+	counts rollingCounts
+}
+
+const defaultBudgetWindow = time.Duration(10) * time.Second
+
+// Deposit records a first attempt.
+func (b *RetryBudget) Deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts.add(b.clock().Now(), b.window(), 1, 0)
+}
+
+// Withdraw records a retry and reports true if the budget allows it. A refused retry is
+// not recorded.
+func (b *RetryBudget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock().Now()
+	attempts, retries := b.counts.sum(now, b.window())
+	allowed := b.Ratio*float64(attempts) + b.MinPerSecond*b.window().Seconds()
+	if float64(retries+1) > allowed {
+		return false
+	}
+	b.counts.add(now, b.window(), 0, 1)
+	return true
+}
+
+func (b *RetryBudget) window() time.Duration {
+	if b.Window <= 0 {
+		return defaultBudgetWindow
+	}
+	return b.Window
+}
+
+func (b *RetryBudget) clock() Clock {
+	if b.Clock == nil {
+		return SystemClock
+	}
+	return b.Clock
+}
+
+// Budgeted gates the retries allowed by Waiter on Budget. Start deposits a first attempt
+// in the budget and every retry withdraws from it. Once the budget refuses a retry,
+// Budgeted gives up without waiting, so Retry returns the last attempt's error right away.
+type Budgeted struct {
+	Budget  *RetryBudget
+	Waiter  PerishableWaiter
+	refused bool
+}
+
+func (b *Budgeted) Start() {
+	b.refused = false
+	b.Budget.Deposit()
+	b.Waiter.Start()
+}
+func (b *Budgeted) IsDying() bool {
+	return b.Waiter.IsDying()
+}
+func (b *Budgeted) WaitOrDie(e error) error {
+	return b.WaitOrDieContext(context.Background(), e)
+}
+func (b *Budgeted) WaitOrDieContext(ctx context.Context, e error) error {
+	return b.waitPlanned(ctx, e, nil)
+}
+func (b *Budgeted) waitPlanned(ctx context.Context, e error, planned func(time.Duration)) error {
+	if _, ok := b.Waiter.(Scheduler); ok {
+		return waitScheduled(ctx, b, e, planned)
+	}
+	if !b.Budget.Withdraw() {
+		b.refused = true
+		return e
+	}
+	return waitOrDie(ctx, b.Waiter, e, planned)
+}
+
+// Next withdraws from the budget only if Waiter plans another attempt. If Waiter is not a
+// Scheduler, Next waits on it directly.
+func (b *Budgeted) Next(e error) (time.Duration, error) {
+	s, ok := b.Waiter.(Scheduler)
+	if !ok {
+		if !b.Budget.Withdraw() {
+			b.refused = true
+			return 0, e
+		}
+		return 0, b.Waiter.WaitOrDie(e)
+	}
+	d, err := s.Next(e)
+	if err != nil {
+		return d, err
+	}
+	if !b.Budget.Withdraw() {
+		b.refused = true
+		return 0, e
+	}
+	return d, nil
+}
+func (b *Budgeted) Deadline() (time.Time, bool) {
+	if s, ok := b.Waiter.(Scheduler); ok {
+		return s.Deadline()
+	}
+	return time.Time{}, false
+}
+func (b *Budgeted) AttemptDeadline() (time.Time, bool) {
+	if ad, ok := b.Waiter.(AttemptDeadliner); ok {
+		return ad.AttemptDeadline()
+	}
+	return time.Time{}, false
+}
+func (b *Budgeted) StopReason() StopReason {
+	if b.refused {
+		return StopBudgetExhausted
+	}
+	return stopReason(b.Waiter)
+}
+func (b *Budgeted) WaitClock() Clock {
+	return ClockOf(b.Waiter)
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	clock := &manualClock{now: time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	b := &RetryBudget{Ratio: 0.2, Window: time.Duration(10) * time.Second, Clock: clock}
+	if b.Withdraw() {
+		t.Fatal(`Allowed a retry before any first attempts`)
+	}
+	for i := 0; i < 10; i++ {
+		b.Deposit()
+	}
+	if !b.Withdraw() || !b.Withdraw() {
+		t.Fatal(`Refused retries within the ratio`)
+	}
+	if b.Withdraw() {
+		t.Fatal(`Allowed a retry beyond the ratio`)
+	}
+
+	// Everything ages out of the window
+	clock.now = clock.now.Add(b.Window)
+	b.Deposit()
+	if b.Withdraw() {
+		t.Fatal(`Counted first attempts outside the window`)
+	}
+
+	b.MinPerSecond = 0.1
+	if !b.Withdraw() {
+		t.Fatal(`Refused a retry within the per-second floor`)
+	}
+}
+
+func TestBudgetedRetry(t *testing.T) {
+	budget := &RetryBudget{Ratio: 0.5}
+	calls := 0
+	last := errors.New(`retriable`)
+	f := func() (interface{}, ClientError) {
+		calls++
+		return nil, RetriableError{E: last}
+	}
+	pw := &Budgeted{
+		Budget: budget,
+		Waiter: &JitteredBackoff{TTL: time.Duration(1) * time.Minute, Bof: NoBackoff, Jf: NoJitter},
+	}
+
+	// the first call deposits half a retry, which is not enough for one
+	if _, e := Retry(f, pw); !errors.Is(e, last) {
+		t.Fatal(`Failed to return the last attempt error`)
+	}
+	if calls != 1 {
+		t.Fatalf(`Expected 1 call without budget, got %v`, calls)
+	}
+
+	// the second deposits the other half
+	calls = 0
+	_, e := Retry(f, pw)
+	if calls != 2 {
+		t.Fatalf(`Expected 2 calls once the budget allowed a retry, got %v`, calls)
+	}
+	var re RetryError
+	if !errors.As(e, &re) || re.Reason != StopBudgetExhausted {
+		t.Fatalf(`Expected to stop on the budget, got %v`, e)
+	}
+}
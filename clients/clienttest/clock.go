@@ -0,0 +1,173 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clienttest provides helpers for testing code built on the clients package.
+package clienttest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/buildertools/svctools-go/clients"
+)
+
+// FakeClock is a clients.Clock that only moves when told to. Timers created from it fire
+// when Advance moves the clock past their expiry.
+//
+// With AutoAdvance set, every timer moves the clock forward to its own expiry as soon as
+// it is created, so code that waits on the clock runs without pausing while the requested
+// waits are still recorded in Sleeps. AutoAdvance must be set before the clock is used.
+//
+// The zero value is ready to use and reads the zero time until it is advanced.
+type FakeClock struct {
+	AutoAdvance bool
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	pending []*fakeTimer
+	sleeps  []time.Duration
+}
+
+// NewFakeClock returns a FakeClock that reads start until it is advanced.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) clients.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	c.sleeps = append(c.sleeps, d)
+	c.schedule(t, d)
+	if c.AutoAdvance {
+		c.advance(d)
+	}
+	return t
+}
+
+// Advance moves the clock forward by d, firing every timer that expires on the way.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance(d)
+}
+
+// Sleeps returns the durations of every timer created so far, in order.
+func (c *FakeClock) Sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.sleeps...)
+}
+
+// BlockUntil blocks until at least n timers are waiting to fire. It lets a test advance
+// the clock only after the code under test has started waiting on it.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.pending) < n {
+		c.waiters().Wait()
+	}
+}
+
+func (c *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	t.when = c.now.Add(d)
+	if d <= 0 {
+		t.fire(c.now)
+		return
+	}
+	// keep pending ordered by expiry
+	i := len(c.pending)
+	for i > 0 && c.pending[i-1].when.After(t.when) {
+		i--
+	}
+	c.pending = append(c.pending, nil)
+	copy(c.pending[i+1:], c.pending[i:])
+	c.pending[i] = t
+	c.waiters().Broadcast()
+}
+
+// waiters returns the condition BlockUntil waits on, creating it on first use. c.mu must
+// be held.
+func (c *FakeClock) waiters() *sync.Cond {
+	if c.cond == nil {
+		c.cond = sync.NewCond(&c.mu)
+	}
+	return c.cond
+}
+
+func (c *FakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	fired := 0
+	for _, t := range c.pending {
+		if t.when.After(c.now) {
+			break
+		}
+		t.fire(c.now)
+		fired++
+	}
+	c.pending = c.pending[fired:]
+}
+
+// stop removes t from the pending timers and reports whether it was pending.
+func (c *FakeClock) stop(t *fakeTimer) bool {
+	for i, p := range c.pending {
+		if p == t {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	c     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.stop(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.stop(t)
+	t.clock.schedule(t, d)
+	return active
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	select {
+	case t.c <- now:
+	default:
+	}
+}
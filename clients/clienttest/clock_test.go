@@ -0,0 +1,121 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clienttest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/buildertools/svctools-go/clients"
+)
+
+func TestFakeClockAdvance(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	if !c.Now().Equal(start) {
+		t.Fatal(`Clock did not start at the given time`)
+	}
+
+	early := c.After(time.Duration(1) * time.Second)
+	late := c.NewTimer(time.Duration(2) * time.Second)
+	stopped := c.NewTimer(time.Duration(1) * time.Second)
+	if !stopped.Stop() {
+		t.Fatal(`Stopping a pending timer reported it was not pending`)
+	}
+
+	c.Advance(time.Duration(1) * time.Second)
+	select {
+	case <-early:
+	default:
+		t.Fatal(`Timer did not fire when the clock reached its expiry`)
+	}
+	select {
+	case <-late.C():
+		t.Fatal(`Timer fired before the clock reached its expiry`)
+	case <-stopped.C():
+		t.Fatal(`Stopped timer fired`)
+	default:
+	}
+
+	c.Advance(time.Duration(1) * time.Second)
+	select {
+	case <-late.C():
+	default:
+		t.Fatal(`Timer did not fire when the clock reached its expiry`)
+	}
+	if !c.Now().Equal(start.Add(time.Duration(2) * time.Second)) {
+		t.Fatal(`Clock did not advance`)
+	}
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	c := NewFakeClock(time.Time{})
+	done := make(chan struct{})
+	go func() {
+		<-c.After(time.Duration(1) * time.Minute)
+		close(done)
+	}()
+	c.BlockUntil(1)
+	c.Advance(time.Duration(1) * time.Minute)
+	<-done
+}
+
+func TestFakeClockZeroValue(t *testing.T) {
+	c := &FakeClock{AutoAdvance: true}
+	<-c.After(time.Duration(1) * time.Second)
+	if !c.Now().Equal(time.Time{}.Add(time.Duration(1) * time.Second)) {
+		t.Fatal(`Zero value clock did not advance`)
+	}
+
+	c = &FakeClock{}
+	done := make(chan struct{})
+	go func() {
+		<-c.After(time.Duration(1) * time.Minute)
+		close(done)
+	}()
+	c.BlockUntil(1)
+	c.Advance(time.Duration(1) * time.Minute)
+	<-done
+}
+
+// The sleep schedule of an exponential backoff is exact on virtual time, including the
+// final wait that is cut short by the TTL.
+func TestJitteredBackoffSchedule(t *testing.T) {
+	c := NewFakeClock(time.Time{})
+	c.AutoAdvance = true
+	f := func() (interface{}, clients.ClientError) {
+		return nil, clients.RetriableError{E: errors.New(`retriable`)}
+	}
+	_, e := clients.Retry(f, &clients.JitteredBackoff{
+		TTL:     time.Duration(1) * time.Second,
+		Initial: time.Duration(10) * time.Millisecond,
+		Bof:     clients.ExponentialBackoff,
+		Jf:      clients.NoJitter,
+		Clock:   c,
+	})
+	if e == nil {
+		t.Fatal(`No error was returned`)
+	}
+	ms := time.Millisecond
+	expected := []time.Duration{10 * ms, 20 * ms, 40 * ms, 80 * ms, 160 * ms, 320 * ms, 370 * ms}
+	if s := c.Sleeps(); !reflect.DeepEqual(s, expected) {
+		t.Fatalf(`Expected sleeps %v, got %v`, expected, s)
+	}
+	if !c.Now().Equal(time.Time{}.Add(time.Duration(1) * time.Second)) {
+		t.Fatalf(`Expected to give up at the TTL, gave up after %v`, c.Now().Sub(time.Time{}))
+	}
+}
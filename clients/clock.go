@@ -0,0 +1,79 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"time"
+)
+
+// Clock is the source of time used by the waiters in this package. Waiters use
+// SystemClock unless another Clock is provided, which lets tests substitute a clock that
+// runs on virtual time.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the Clock equivalent of *time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the Clock backed by the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (s systemTimer) C() <-chan time.Time {
+	return s.t.C
+}
+func (s systemTimer) Stop() bool {
+	return s.t.Stop()
+}
+func (s systemTimer) Reset(d time.Duration) bool {
+	return s.t.Reset(d)
+}
+
+// Clocked is implemented by waiters that say which Clock they wait on. The retry loop
+// measures attempts, waits and elapsed time on it. Every waiter in this package
+// implements it.
+type Clocked interface {
+	WaitClock() Clock
+}
+
+// ClockOf returns the Clock used by pw, or SystemClock if pw does not implement Clocked.
+func ClockOf(pw PerishableWaiter) Clock {
+	if c, ok := pw.(Clocked); ok {
+		return c.WaitClock()
+	}
+	return SystemClock
+}
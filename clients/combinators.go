@@ -0,0 +1,372 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"context"
+	"time"
+)
+
+// Combined is a PerishableWaiter assembled from other waiters by AllOf or AnyOf.
+//
+// Components that implement Scheduler are planned together and share a single wait.
+// Components that only implement PerishableWaiter wait in turn after that shared wait, so
+// their delays add to it.
+type Combined struct {
+	any       bool
+	ws        []PerishableWaiter
+	alive     []bool
+	stoppedBy PerishableWaiter
+}
+
+// AllOf combines waiters into one that keeps retrying only while all of them do. It gives
+// up as soon as any component gives up, waits for the longest delay any component asks
+// for, and expires with the earliest component deadline.
+func AllOf(ws ...PerishableWaiter) *Combined {
+	return &Combined{ws: ws}
+}
+
+// AnyOf combines waiters into one that keeps retrying while at least one of them does.
+// Components that have given up are ignored from then on. It waits for the shortest delay
+// the remaining components ask for and expires with the latest of their deadlines. AnyOf
+// with no waiters gives up at once.
+func AnyOf(ws ...PerishableWaiter) *Combined {
+	return &Combined{any: true, ws: ws}
+}
+
+// StoppedBy returns the component that caused the combined waiter to give up, or nil if it
+// has not given up. For AnyOf this is the last component to give up.
+func (c *Combined) StoppedBy() PerishableWaiter {
+	return c.stoppedBy
+}
+
+// StopReason is the reason given by the component that caused the combined waiter to give
+// up.
+func (c *Combined) StopReason() StopReason {
+	if c.stoppedBy == nil {
+		return StopExpired
+	}
+	return stopReason(c.stoppedBy)
+}
+
+func (c *Combined) Start() {
+	c.stoppedBy = nil
+	c.alive = make([]bool, len(c.ws))
+	for i, w := range c.ws {
+		c.alive[i] = true
+		w.Start()
+	}
+}
+func (c *Combined) IsDying() bool {
+	return c.alive != nil
+}
+func (c *Combined) WaitOrDie(e error) error {
+	return c.WaitOrDieContext(context.Background(), e)
+}
+func (c *Combined) WaitOrDieContext(ctx context.Context, e error) error {
+	return c.waitPlanned(ctx, e, nil)
+}
+
+// waitPlanned reports the shared wait of the Scheduler components before it begins. The
+// waits of the other components add to it unreported, unless there is no Scheduler
+// component left, in which case their waits are reported together once they are over.
+func (c *Combined) waitPlanned(ctx context.Context, e error, planned func(time.Duration)) error {
+	d, err := c.Next(e)
+	if err != nil {
+		return err
+	}
+	if !c.scheduled() {
+		var waited time.Duration
+		err = c.waitOpaque(ctx, e, func(d time.Duration) { waited += d })
+		if err == nil {
+			report(planned, waited)
+		}
+		return err
+	}
+	deadline, _ := c.Deadline()
+	if err := sleep(ctx, c.WaitClock(), d, deadline, e, planned); err != nil {
+		if ctx.Err() == nil {
+			c.expire(deadline)
+		}
+		return err
+	}
+	return c.waitOpaque(ctx, e, nil)
+}
+
+// scheduled reports whether any component that has not given up is a Scheduler.
+func (c *Combined) scheduled() bool {
+	for i, w := range c.ws {
+		if _, ok := w.(Scheduler); ok && c.live(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next plans the shared wait of the Scheduler components. Components that are not
+// Schedulers are not consulted.
+func (c *Combined) Next(e error) (time.Duration, error) {
+	if (c.any && len(c.ws) == 0) || c.expire(c.WaitClock().Now()) {
+		return 0, e
+	}
+	var d time.Duration
+	planned := false
+	for i, w := range c.ws {
+		s, ok := w.(Scheduler)
+		if !ok || !c.live(i) {
+			continue
+		}
+		sd, err := s.Next(e)
+		if err != nil {
+			if c.stop(i) {
+				return 0, err
+			}
+			continue
+		}
+		if !planned || (c.any && sd < d) || (!c.any && sd > d) {
+			d = sd
+		}
+		planned = true
+	}
+	return d, nil
+}
+
+func (c *Combined) Deadline() (time.Time, bool) {
+	var deadline time.Time
+	for i, w := range c.ws {
+		if !c.live(i) {
+			continue
+		}
+		s, ok := w.(Scheduler)
+		if !ok {
+			if c.any {
+				return time.Time{}, false
+			}
+			continue
+		}
+		sd, ok := s.Deadline()
+		if !ok {
+			if c.any {
+				return time.Time{}, false
+			}
+			continue
+		}
+		if deadline.IsZero() || (c.any && sd.After(deadline)) || (!c.any && sd.Before(deadline)) {
+			deadline = sd
+		}
+	}
+	return deadline, !deadline.IsZero()
+}
+
+func (c *Combined) AttemptDeadline() (time.Time, bool) {
+	var deadline time.Time
+	for i, w := range c.ws {
+		if !c.live(i) {
+			continue
+		}
+		ad, ok := w.(AttemptDeadliner)
+		if !ok {
+			if c.any {
+				return time.Time{}, false
+			}
+			continue
+		}
+		d, ok := ad.AttemptDeadline()
+		if !ok {
+			if c.any {
+				return time.Time{}, false
+			}
+			continue
+		}
+		if deadline.IsZero() || (c.any && d.After(deadline)) || (!c.any && d.Before(deadline)) {
+			deadline = d
+		}
+	}
+	return deadline, !deadline.IsZero()
+}
+
+// WaitClock returns the Clock of the first component.
+func (c *Combined) WaitClock() Clock {
+	if len(c.ws) == 0 {
+		return SystemClock
+	}
+	return ClockOf(c.ws[0])
+}
+
+// waitOpaque waits in turn on the components that are not Schedulers.
+func (c *Combined) waitOpaque(ctx context.Context, e error, planned func(time.Duration)) error {
+	for i, w := range c.ws {
+		if _, ok := w.(Scheduler); ok || !c.live(i) {
+			continue
+		}
+		if err := waitOrDie(ctx, w, e, planned); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			if c.stop(i) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expire marks the Scheduler components whose deadline is not after t as having given up
+// and reports whether the combined waiter gives up as a result.
+func (c *Combined) expire(t time.Time) bool {
+	for i, w := range c.ws {
+		s, ok := w.(Scheduler)
+		if !ok || !c.live(i) {
+			continue
+		}
+		if sd, ok := s.Deadline(); ok && !sd.After(t) && c.stop(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// live reports whether component i has not given up. Every component is live until the
+// first Start.
+func (c *Combined) live(i int) bool {
+	return c.alive == nil || c.alive[i]
+}
+
+// stop marks component i as having given up and reports whether the combined waiter gives
+// up as a result.
+func (c *Combined) stop(i int) bool {
+	if c.alive == nil {
+		c.alive = make([]bool, len(c.ws))
+		for j := range c.alive {
+			c.alive[j] = true
+		}
+	}
+	c.alive[i] = false
+	if c.any {
+		for _, a := range c.alive {
+			if a {
+				return false
+			}
+		}
+	}
+	c.stoppedBy = c.ws[i]
+	return true
+}
+
+// Capped limits each wait planned by Waiter to at most Max, or to the delay hinted by the
+// error being retried if that is longer.
+type Capped struct {
+	Waiter Scheduler
+	Max    time.Duration
+}
+
+func (c *Capped) Start() {
+	c.Waiter.Start()
+}
+func (c *Capped) IsDying() bool {
+	return c.Waiter.IsDying()
+}
+func (c *Capped) WaitOrDie(e error) error {
+	return c.WaitOrDieContext(context.Background(), e)
+}
+func (c *Capped) WaitOrDieContext(ctx context.Context, e error) error {
+	return c.waitPlanned(ctx, e, nil)
+}
+func (c *Capped) waitPlanned(ctx context.Context, e error, planned func(time.Duration)) error {
+	return waitScheduled(ctx, c, e, planned)
+}
+func (c *Capped) Next(e error) (time.Duration, error) {
+	d, err := c.Waiter.Next(e)
+	if err != nil || d <= c.Max {
+		return d, err
+	}
+	if hint, ok := DelayHint(e); ok && hint > c.Max {
+		return hint, nil
+	}
+	return c.Max, nil
+}
+func (c *Capped) Deadline() (time.Time, bool) {
+	return c.Waiter.Deadline()
+}
+func (c *Capped) StopReason() StopReason {
+	return stopReason(c.Waiter)
+}
+func (c *Capped) WaitClock() Clock {
+	return ClockOf(c.Waiter)
+}
+func (c *Capped) AttemptDeadline() (time.Time, bool) {
+	if ad, ok := c.Waiter.(AttemptDeadliner); ok {
+		return ad.AttemptDeadline()
+	}
+	return time.Time{}, false
+}
+
+// MaxSleep gives up once the waits planned by Waiter would add up to more than Max.
+type MaxSleep struct {
+	Waiter Scheduler
+	Max    time.Duration
+	slept  time.Duration
+	spent  bool
+}
+
+func (m *MaxSleep) Start() {
+	m.slept = 0
+	m.spent = false
+	m.Waiter.Start()
+}
+func (m *MaxSleep) IsDying() bool {
+	return m.Waiter.IsDying()
+}
+func (m *MaxSleep) WaitOrDie(e error) error {
+	return m.WaitOrDieContext(context.Background(), e)
+}
+func (m *MaxSleep) WaitOrDieContext(ctx context.Context, e error) error {
+	return m.waitPlanned(ctx, e, nil)
+}
+func (m *MaxSleep) waitPlanned(ctx context.Context, e error, planned func(time.Duration)) error {
+	return waitScheduled(ctx, m, e, planned)
+}
+func (m *MaxSleep) Next(e error) (time.Duration, error) {
+	d, err := m.Waiter.Next(e)
+	if err != nil {
+		return d, err
+	}
+	if m.slept+d > m.Max {
+		m.spent = true
+		return 0, e
+	}
+	m.slept += d
+	return d, nil
+}
+func (m *MaxSleep) Deadline() (time.Time, bool) {
+	return m.Waiter.Deadline()
+}
+
+// StopReason reports an exhausted sleep budget as expiry.
+func (m *MaxSleep) StopReason() StopReason {
+	if m.spent {
+		return StopExpired
+	}
+	return stopReason(m.Waiter)
+}
+func (m *MaxSleep) WaitClock() Clock {
+	return ClockOf(m.Waiter)
+}
+func (m *MaxSleep) AttemptDeadline() (time.Time, bool) {
+	if ad, ok := m.Waiter.(AttemptDeadliner); ok {
+		return ad.AttemptDeadline()
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fixedDelay is a Scheduler that always plans the same delay and never expires.
+type fixedDelay struct {
+	d       time.Duration
+	started bool
+}
+
+func (f *fixedDelay) Start()                      { f.started = true }
+func (f *fixedDelay) IsDying() bool               { return f.started }
+func (f *fixedDelay) WaitOrDie(e error) error     { return nil }
+func (f *fixedDelay) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (f *fixedDelay) Next(e error) (time.Duration, error) {
+	return f.d, nil
+}
+
+func TestAllOf(t *testing.T) {
+	ie := errors.New(`e1`)
+	short := &fixedDelay{d: time.Duration(1)}
+	long := &fixedDelay{d: time.Duration(2)}
+	limit := &MaxAttempts{N: 2}
+	pw := AllOf(short, long, limit)
+	pw.Start()
+	if !pw.IsDying() {
+		t.Fatal(`Should be dying by now`)
+	}
+
+	d, e := pw.Next(ie)
+	if e != nil {
+		t.Fatal(`Gave up before any component did`)
+	}
+	if d != time.Duration(2) {
+		t.Fatalf(`Expected the longest delay, got %v`, d)
+	}
+	if pw.StoppedBy() != nil {
+		t.Fatal(`Reported a stopping component while still alive`)
+	}
+	if _, e = pw.Next(ie); e != ie {
+		t.Fatal(`Failed to give up with the input error when one component gave up`)
+	}
+	if pw.StoppedBy() != limit {
+		t.Fatal(`Failed to report the component that gave up`)
+	}
+}
+
+func TestAllOfDeadline(t *testing.T) {
+	ie := errors.New(`e1`)
+	ttl := &JitteredBackoff{Bof: NoBackoff, Jf: NoJitter}
+	pw := AllOf(&MaxAttempts{N: 10}, ttl)
+	pw.Start()
+	if e := pw.WaitOrDie(ie); e != ie {
+		t.Fatal(`Failed to give up when the earliest deadline passed`)
+	}
+	if pw.StoppedBy() != ttl {
+		t.Fatal(`Failed to report the expired component`)
+	}
+}
+
+func TestAnyOf(t *testing.T) {
+	ie := errors.New(`e1`)
+	first := &MaxAttempts{N: 2, Waiter: &fixedDelay{d: time.Duration(5)}}
+	second := &MaxAttempts{N: 3, Waiter: &fixedDelay{d: time.Duration(10)}}
+	pw := AnyOf(first, second)
+	pw.Start()
+
+	if d, e := pw.Next(ie); e != nil || d != time.Duration(5) {
+		t.Fatalf(`Expected the shortest delay, got %v, %v`, d, e)
+	}
+	if d, e := pw.Next(ie); e != nil || d != time.Duration(10) {
+		t.Fatalf(`Expected the delay of the remaining component, got %v, %v`, d, e)
+	}
+	if _, e := pw.Next(ie); e != ie {
+		t.Fatal(`Failed to give up once every component gave up`)
+	}
+	if pw.StoppedBy() != second {
+		t.Fatal(`Failed to report the last component to give up`)
+	}
+}
+
+func TestCombinedBeforeStart(t *testing.T) {
+	ie := errors.New(`e1`)
+	pw := AllOf(&fixedDelay{d: time.Duration(1)}, &MaxAttempts{N: 1})
+	if _, ok := pw.Deadline(); ok {
+		t.Fatal(`Reported a deadline no component has`)
+	}
+	if _, e := pw.Next(ie); e != ie {
+		t.Fatal(`Failed to give up when a component gave up before Start`)
+	}
+}
+
+func TestAnyOfEmpty(t *testing.T) {
+	ie := errors.New(`e1`)
+	pw := AnyOf()
+	pw.Start()
+	if _, e := pw.Next(ie); e != ie {
+		t.Fatal(`AnyOf with no waiters failed to give up`)
+	}
+	if pw.StopReason() != StopExpired {
+		t.Fatalf(`Expected to expire, got %v`, pw.StopReason())
+	}
+	if _, err := Retry(func() (interface{}, ClientError) {
+		return nil, Retriable(ie)
+	}, AnyOf()); err == nil {
+		t.Fatal(`Retried with AnyOf with no waiters`)
+	}
+}
+
+func TestCapped(t *testing.T) {
+	pw := &Capped{Waiter: &fixedDelay{d: time.Duration(1) * time.Hour}, Max: time.Duration(1)}
+	pw.Start()
+	if d, e := pw.Next(nil); e != nil || d != time.Duration(1) {
+		t.Fatalf(`Delay was not capped: %v`, d)
+	}
+	hinted := RetriableError{E: HTTPError{RetryAfter: time.Duration(1) * time.Minute}}
+	if d, e := pw.Next(hinted); e != nil || d != time.Duration(1)*time.Minute {
+		t.Fatalf(`Delay was capped below the hinted delay: %v`, d)
+	}
+}
+
+func TestMaxSleep(t *testing.T) {
+	ie := errors.New(`e1`)
+	pw := &MaxSleep{Waiter: &fixedDelay{d: time.Duration(4)}, Max: time.Duration(10)}
+	pw.Start()
+	for i := 0; i < 2; i++ {
+		if _, e := pw.Next(ie); e != nil {
+			t.Fatalf(`Gave up after %v waits`, i)
+		}
+	}
+	if _, e := pw.Next(ie); e != ie {
+		t.Fatal(`Failed to give up once the sleep budget was spent`)
+	}
+
+	// Start resets the budget
+	pw.Start()
+	if _, e := pw.Next(ie); e != nil {
+		t.Fatal(`Start did not reset the sleep budget`)
+	}
+}
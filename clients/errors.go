@@ -15,12 +15,23 @@
 package clients
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 )
 
+// ClientError is an error classified as retriable or not. Cause returns the error that was
+// classified, which is also what the ClientError unwraps to, so errors.Is and errors.As
+// see through the classification.
+//
+// Earlier versions declared Error() error in place of Cause and so were not errors
+// themselves. Code that called Error() to get at the underlying error should call Cause()
+// instead.
 type ClientError interface {
+	error
 	IsRetriable() bool
-	Error() error
+	Cause() error
 }
 
 type RetriableError struct {
@@ -30,7 +41,16 @@ type RetriableError struct {
 func (r RetriableError) IsRetriable() bool {
 	return true
 }
-func (r RetriableError) Error() error {
+func (r RetriableError) Error() string {
+	if r.E == nil {
+		return `retriable error`
+	}
+	return r.E.Error()
+}
+func (r RetriableError) Cause() error {
+	return r.E
+}
+func (r RetriableError) Unwrap() error {
 	return r.E
 }
 
@@ -41,57 +61,129 @@ type NonRetriableError struct {
 func (n NonRetriableError) IsRetriable() bool {
 	return false
 }
-func (n NonRetriableError) Error() error {
+func (n NonRetriableError) Error() string {
+	if n.E == nil {
+		return `non-retriable error`
+	}
+	return n.E.Error()
+}
+func (n NonRetriableError) Cause() error {
+	return n.E
+}
+func (n NonRetriableError) Unwrap() error {
 	return n.E
 }
 
-func WrapHttpResponseError(r *http.Response, err error) (*http.Response, ClientError) {
-	if r == nil && err == nil {
-		return nil, nil
-	} else if err != nil {
-		return r, RetriableError{E: err}
+// Retriable classifies err as retriable. It returns nil if err is nil.
+func Retriable(err error) ClientError {
+	if err == nil {
+		return nil
 	}
+	return RetriableError{E: err}
+}
 
-	if r.StatusCode == http.StatusBadRequest ||
-		r.StatusCode == http.StatusUnauthorized ||
-		r.StatusCode == http.StatusPaymentRequired ||
-		r.StatusCode == http.StatusForbidden ||
-		r.StatusCode == http.StatusNotFound ||
-		r.StatusCode == http.StatusMethodNotAllowed ||
-		r.StatusCode == http.StatusNotAcceptable ||
-		r.StatusCode == http.StatusProxyAuthRequired ||
-		r.StatusCode == http.StatusRequestTimeout ||
-		r.StatusCode == http.StatusConflict ||
-		r.StatusCode == http.StatusGone ||
-		r.StatusCode == http.StatusLengthRequired ||
-		r.StatusCode == http.StatusPreconditionFailed ||
-		r.StatusCode == http.StatusRequestEntityTooLarge ||
-		r.StatusCode == http.StatusRequestURITooLong ||
-		r.StatusCode == http.StatusUnsupportedMediaType ||
-		r.StatusCode == http.StatusRequestedRangeNotSatisfiable ||
-		r.StatusCode == http.StatusExpectationFailed ||
-		r.StatusCode == http.StatusTeapot ||
-		r.StatusCode == http.StatusUnprocessableEntity ||
-		r.StatusCode == http.StatusLocked ||
-		r.StatusCode == http.StatusFailedDependency ||
-		r.StatusCode == http.StatusUpgradeRequired ||
-		r.StatusCode == http.StatusPreconditionRequired ||
-		r.StatusCode == http.StatusTooManyRequests ||
-		r.StatusCode == http.StatusRequestHeaderFieldsTooLarge ||
-		r.StatusCode == http.StatusUnavailableForLegalReasons {
-		return r, NonRetriableError{E: err}
-	} else if r.StatusCode == http.StatusInternalServerError ||
-		r.StatusCode == http.StatusNotImplemented ||
-		r.StatusCode == http.StatusBadGateway ||
-		r.StatusCode == http.StatusServiceUnavailable ||
-		r.StatusCode == http.StatusGatewayTimeout ||
-		r.StatusCode == http.StatusHTTPVersionNotSupported ||
-		r.StatusCode == http.StatusVariantAlsoNegotiates ||
-		r.StatusCode == http.StatusInsufficientStorage ||
-		r.StatusCode == http.StatusLoopDetected ||
-		r.StatusCode == http.StatusNotExtended ||
-		r.StatusCode == http.StatusNetworkAuthenticationRequired {
-		return r, RetriableError{E: err}
+// NonRetriable classifies err as non-retriable. It returns nil if err is nil.
+func NonRetriable(err error) ClientError {
+	if err == nil {
+		return nil
 	}
-	return r, nil
+	return NonRetriableError{E: err}
+}
+
+// IsRetriable reports whether the first classified error in the chain of err is
+// retriable. Errors that were never classified are not retriable.
+func IsRetriable(err error) bool {
+	var c interface {
+		IsRetriable() bool
+	}
+	if errors.As(err, &c) {
+		return c.IsRetriable()
+	}
+	return false
+}
+
+// causeOf returns the cause of err, or err itself if it has none.
+func causeOf(err ClientError) error {
+	if c := err.Cause(); c != nil {
+		return c
+	}
+	return err
+}
+
+// CancelledError is returned by RetryContext when the context is done before an attempt
+// succeeds. Err is the context error and Last is the error from the final attempt, if any.
+type CancelledError struct {
+	Err  error
+	Last error
+}
+
+func (c CancelledError) Unwrap() error {
+	return c.Err
+}
+func (c CancelledError) Error() string {
+	if c.Last != nil {
+		return fmt.Sprintf("retry cancelled: %v (last error: %v)", c.Err, c.Last)
+	}
+	return fmt.Sprintf("retry cancelled: %v", c.Err)
+}
+
+// StopReason says why Retry gave up.
+type StopReason int
+
+const (
+	// StopNonRetriable means an attempt failed with an error that is not retriable.
+	StopNonRetriable StopReason = iota
+	// StopExpired means the waiter ran out of time.
+	StopExpired
+	// StopExhausted means the waiter ran out of attempts.
+	StopExhausted
+	// StopCancelled means the context passed to RetryContext was done.
+	StopCancelled
+	// StopBudgetExhausted means a RetryBudget refused the retry.
+	StopBudgetExhausted
+)
+
+func (s StopReason) String() string {
+	switch s {
+	case StopNonRetriable:
+		return `non-retriable`
+	case StopExpired:
+		return `expired`
+	case StopExhausted:
+		return `attempts exhausted`
+	case StopCancelled:
+		return `cancelled`
+	case StopBudgetExhausted:
+		return `budget exhausted`
+	}
+	return `unknown`
+}
+
+// StopReasoner is implemented by waiters that can say why they gave up. Retry treats
+// waiters that do not implement it as having expired.
+type StopReasoner interface {
+	StopReason() StopReason
+}
+
+// RetryError is returned by Retry when it gives up. Errors holds the ClientError returned
+// by every attempt, in order. Err is the final cause: the cause of the last attempt's
+// error, or a CancelledError if the retry was cancelled. RetryError unwraps to Err.
+type RetryError struct {
+	Attempts int
+	Elapsed  time.Duration
+	Reason   StopReason
+	Errors   []error
+	Err      error
+}
+
+func (r RetryError) Error() string {
+	return fmt.Sprintf("gave up after %d attempts in %v (%v): %v", r.Attempts, r.Elapsed, r.Reason, r.Err)
+}
+func (r RetryError) Unwrap() error {
+	return r.Err
+}
+
+// WrapHttpResponseError classifies the result of an HTTP call with DefaultHTTPClassifier.
+func WrapHttpResponseError(r *http.Response, err error) (*http.Response, ClientError) {
+	return DefaultHTTPClassifier.Classify(r, err)
 }
@@ -15,9 +15,12 @@
 package clients
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestRetriableError(t *testing.T) {
@@ -26,15 +29,22 @@ func TestRetriableError(t *testing.T) {
 	if !e.IsRetriable() {
 		t.Fatal(`RetriableError was notretriable.`)
 	}
-	if e.Error() != nil {
-		t.Fatal(`RetriableError with nil error returned non-nil error.`)
+	if e.Cause() != nil {
+		t.Fatal(`RetriableError with nil error returned non-nil cause.`)
 	}
-	e = RetriableError{E: errors.New(`non-nil error`)}
+	if e.Error() == `` {
+		t.Fatal(`RetriableError with nil error returned an empty message.`)
+	}
+	ie := errors.New(`non-nil error`)
+	e = RetriableError{E: ie}
 	if !e.IsRetriable() {
 		t.Fatal(`RetriableError was notretriable.`)
 	}
-	if e.Error() == nil {
-		t.Fatal(`RetriableError with non-nil error returned nil error.`)
+	if e.Cause() != ie {
+		t.Fatal(`RetriableError with non-nil error did not return it as the cause.`)
+	}
+	if e.Error() != ie.Error() {
+		t.Fatal(`RetriableError did not use the message of its cause.`)
 	}
 }
 
@@ -44,15 +54,53 @@ func TestNonRetriableError(t *testing.T) {
 	if e.IsRetriable() {
 		t.Fatal(`NonRetriableError was retriable.`)
 	}
-	if e.Error() != nil {
-		t.Fatal(`NonRetriableError with nil error returned non-nil error.`)
+	if e.Cause() != nil {
+		t.Fatal(`NonRetriableError with nil error returned non-nil cause.`)
 	}
-	e = NonRetriableError{E: errors.New(`non-nil error`)}
+	if e.Error() == `` {
+		t.Fatal(`NonRetriableError with nil error returned an empty message.`)
+	}
+	ie := errors.New(`non-nil error`)
+	e = NonRetriableError{E: ie}
 	if e.IsRetriable() {
 		t.Fatal(`NonRetriableError was retriable.`)
 	}
-	if e.Error() == nil {
-		t.Fatal(`NonRetriableError with non-nil error returned nil error.`)
+	if e.Cause() != ie {
+		t.Fatal(`NonRetriableError with non-nil error did not return it as the cause.`)
+	}
+	if e.Error() != ie.Error() {
+		t.Fatal(`NonRetriableError did not use the message of its cause.`)
+	}
+}
+
+func TestClientErrorWrapping(t *testing.T) {
+	ie := errors.New(`cause`)
+	wrapped := fmt.Errorf(`calling service: %w`, Retriable(ie))
+	if !errors.Is(wrapped, ie) {
+		t.Fatal(`errors.Is did not find the cause through the classification.`)
+	}
+	var re RetriableError
+	if !errors.As(wrapped, &re) || re.E != ie {
+		t.Fatal(`errors.As did not find the RetriableError.`)
+	}
+	if !IsRetriable(wrapped) {
+		t.Fatal(`Wrapped retriable error was not retriable.`)
+	}
+	if IsRetriable(fmt.Errorf(`calling service: %w`, NonRetriable(ie))) {
+		t.Fatal(`Wrapped non-retriable error was retriable.`)
+	}
+	if IsRetriable(ie) {
+		t.Fatal(`Unclassified error was retriable.`)
+	}
+	if Retriable(nil) != nil || NonRetriable(nil) != nil {
+		t.Fatal(`Classifying a nil error did not return nil.`)
+	}
+}
+
+func TestCancelledErrorUnwrap(t *testing.T) {
+	e := fmt.Errorf(`request: %w`, CancelledError{Err: context.Canceled})
+	if !errors.Is(e, context.Canceled) {
+		t.Fatal(`CancelledError did not unwrap to the context error.`)
 	}
 }
 
@@ -107,3 +155,47 @@ func TestWrapHttpResponseError(t *testing.T) {
 		t.Fatal(`500 response and non-nil error input failed to return the original response`)
 	}
 }
+
+func TestWrapHttpResponseErrorRetryAfter(t *testing.T) {
+	throttled := func(code int, retryAfter string) *http.Response {
+		r := &http.Response{StatusCode: code, Header: http.Header{}}
+		if retryAfter != `` {
+			r.Header.Set(`Retry-After`, retryAfter)
+		}
+		return r
+	}
+
+	_, e := WrapHttpResponseError(throttled(http.StatusTooManyRequests, ``), nil)
+	if e == nil || e.IsRetriable() {
+		t.Fatal(`429 without Retry-After was retriable`)
+	}
+	var he HTTPError
+	if !errors.As(e, &he) || he.StatusCode != http.StatusTooManyRequests {
+		t.Fatal(`429 did not carry an HTTPError`)
+	}
+
+	_, e = WrapHttpResponseError(throttled(http.StatusTooManyRequests, `120`), nil)
+	if e == nil || !e.IsRetriable() {
+		t.Fatal(`429 with Retry-After was not retriable`)
+	}
+	if d, ok := DelayHint(e); !ok || d != time.Duration(120)*time.Second {
+		t.Fatalf(`429 with Retry-After in seconds hinted %v`, d)
+	}
+
+	date := time.Now().Add(time.Duration(1) * time.Hour).UTC().Format(http.TimeFormat)
+	_, e = WrapHttpResponseError(throttled(http.StatusServiceUnavailable, date), nil)
+	if e == nil || !e.IsRetriable() {
+		t.Fatal(`503 with Retry-After was not retriable`)
+	}
+	if d, ok := DelayHint(e); !ok || d < time.Duration(59)*time.Minute || d > time.Duration(1)*time.Hour {
+		t.Fatalf(`503 with Retry-After as an HTTP-date hinted %v`, d)
+	}
+
+	_, e = WrapHttpResponseError(throttled(http.StatusServiceUnavailable, `soon`), nil)
+	if e == nil || !e.IsRetriable() {
+		t.Fatal(`503 with an unreadable Retry-After was not retriable`)
+	}
+	if _, ok := DelayHint(e); ok {
+		t.Fatal(`503 with an unreadable Retry-After carried a hint`)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"context"
+	"errors"
+)
+
+// RetryT is the typed equivalent of Retry. f reports a failure with any error: one that
+// is or wraps a ClientError is classified by it, and any other error is not retried. On
+// failure RetryT returns the result of the last attempt along with the error.
+func RetryT[T any](f func() (T, error), pw PerishableWaiter, obs ...Observer) (T, error) {
+	return RetryContextT(context.Background(), func(context.Context) (T, error) {
+		return f()
+	}, pw, obs...)
+}
+
+// RetryContextT is the typed equivalent of RetryContext.
+func RetryContextT[T any](ctx context.Context, f func(ctx context.Context) (T, error), pw PerishableWaiter, obs ...Observer) (T, error) {
+	return Typed[T](RetryContext(ctx, Untyped(f), pw, obs...))
+}
+
+// RetryNT is the typed equivalent of RetryN.
+func RetryNT[T any](f func() (T, error), attempts uint, pw PerishableWaiter) (T, error) {
+	return RetryT(f, &MaxAttempts{N: attempts, Waiter: pw})
+}
+
+// Untyped adapts a typed function to a CancellableFunc, classifying its errors the way
+// RetryT does.
+func Untyped[T any](f func(ctx context.Context) (T, error)) CancellableFunc {
+	return func(ctx context.Context) (interface{}, ClientError) {
+		r, err := f(ctx)
+		return r, classify(err)
+	}
+}
+
+// Typed converts the result of a retry of an Untyped function back to T. A nil result
+// becomes the zero value of T.
+func Typed[T any](r interface{}, err error) (T, error) {
+	t, _ := r.(T)
+	return t, err
+}
+
+// classify returns err as a ClientError, keeping the classification of any ClientError
+// it wraps. Unclassified errors are not retriable.
+func classify(err error) ClientError {
+	if err == nil {
+		return nil
+	}
+	if ce, ok := err.(ClientError); ok {
+		return ce
+	}
+	var ce ClientError
+	if errors.As(err, &ce) && ce.IsRetriable() {
+		return Retriable(err)
+	}
+	return NonRetriable(err)
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryT(t *testing.T) {
+	pw := &JitteredBackoff{TTL: time.Duration(1) * time.Minute, Bof: NoBackoff, Jf: NoJitter}
+	calls := 0
+	r, err := RetryT(func() (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, Retriable(errors.New(`e1`))
+		}
+		return 42, nil
+	}, pw)
+	if err != nil || r != 42 || calls != 3 {
+		t.Fatalf(`Expected 42 after 3 calls, got %v, %v after %v calls`, r, err, calls)
+	}
+
+	// wrapped classifications are kept
+	calls = 0
+	ie := errors.New(`e2`)
+	r, err = RetryT(func() (int, error) {
+		calls++
+		if calls < 2 {
+			return 0, fmt.Errorf(`wrapped: %w`, Retriable(ie))
+		}
+		return 7, NonRetriable(ie)
+	}, pw)
+	if !errors.Is(err, ie) || r != 7 || calls != 2 {
+		t.Fatalf(`Expected the last result and error after 2 calls, got %v, %v after %v calls`, r, err, calls)
+	}
+
+	// unclassified errors are not retried
+	calls = 0
+	_, err = RetryT(func() (*int, error) {
+		calls++
+		return nil, ie
+	}, pw)
+	var re RetryError
+	if !errors.As(err, &re) || re.Reason != StopNonRetriable || !errors.Is(err, ie) || calls != 1 {
+		t.Fatalf(`Expected a single non-retriable attempt, got %v after %v calls`, err, calls)
+	}
+}
+
+func TestRetryContextT(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r, err := RetryContextT(ctx, func(context.Context) (string, error) {
+		return `unexpected`, nil
+	}, &JitteredBackoff{TTL: time.Duration(1) * time.Minute, Bof: NoBackoff, Jf: NoJitter})
+	if r != `` || !errors.As(err, new(CancelledError)) {
+		t.Fatalf(`Expected a zero result and a CancelledError, got %q, %v`, r, err)
+	}
+}
+
+func TestRetryNT(t *testing.T) {
+	calls := 0
+	_, err := RetryNT(func() (bool, error) {
+		calls++
+		return false, Retriable(errors.New(`e1`))
+	}, 2, &JitteredBackoff{TTL: time.Duration(1) * time.Minute, Bof: NoBackoff, Jf: NoJitter})
+	if err == nil || calls != 2 {
+		t.Fatalf(`Expected to give up after 2 calls, got %v after %v calls`, err, calls)
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPError is the cause of the ClientError returned for an unsuccessful HTTP response.
+// RetryAfter is the delay the server asked for in a Retry-After header, if it sent one.
+// Body holds the start of the response body when the classifier was asked to capture it.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (h HTTPError) Error() string {
+	status := h.Status
+	if status == `` {
+		status = fmt.Sprintf("%d %s", h.StatusCode, http.StatusText(h.StatusCode))
+	}
+	if h.Body != `` {
+		return fmt.Sprintf("unexpected HTTP status: %s: %q", status, h.Body)
+	}
+	return fmt.Sprintf("unexpected HTTP status: %s", status)
+}
+
+// DelayHint returns RetryAfter.
+func (h HTTPError) DelayHint() time.Duration {
+	return h.RetryAfter
+}
+
+// DelayHinter is implemented by errors that carry the minimum delay a server asked for
+// before the next attempt.
+type DelayHinter interface {
+	DelayHint() time.Duration
+}
+
+// DelayHint returns the first positive delay hint in the chain of err.
+func DelayHint(err error) (time.Duration, bool) {
+	var h DelayHinter
+	if errors.As(err, &h) && h.DelayHint() > 0 {
+		return h.DelayHint(), true
+	}
+	return 0, false
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP-date.
+func parseRetryAfter(r *http.Response, now time.Time) (time.Duration, bool) {
+	v := strings.TrimSpace(r.Header.Get(`Retry-After`))
+	if v == `` {
+		return 0, false
+	}
+	if s, err := strconv.ParseUint(v, 10, 32); err == nil {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// HTTPDecision is the classification of an HTTP response.
+type HTTPDecision int
+
+const (
+	// HTTPSuccess means the response is not an error.
+	HTTPSuccess HTTPDecision = iota
+	// HTTPRetriable means the response is a retriable error.
+	HTTPRetriable
+	// HTTPNonRetriable means the response is an error that is not retriable.
+	HTTPNonRetriable
+	// HTTPThrottled means the response is a retriable error if it carries a Retry-After
+	// header and an error that is not retriable otherwise.
+	HTTPThrottled
+)
+
+// HTTPRange applies Decision to the status codes From through To inclusive.
+type HTTPRange struct {
+	From, To int
+	Decision HTTPDecision
+}
+
+// HTTPClassifier decides which HTTP responses are errors and whether they are retriable.
+//
+// Custom is consulted first and decides whenever it returns true. Otherwise Codes, then
+// Ranges, then the default table are consulted in turn; among overlapping Ranges the last
+// one wins. The default table keeps the decisions WrapHttpResponseError has always made:
+// 429 is throttled; 400 to 418, 422 to 424, 426, 428, 431 and 451 are non-retriable; 500
+// to 508, 510 and 511 are retriable; and everything else, including 421 and 425, is
+// success. Add other codes to Codes to change that.
+//
+// Errors from the transport are always retriable. The cause of every error for a response
+// is an HTTPError, carrying the delay from any Retry-After header the response had.
+//
+// When BodySnippet is positive, up to that many bytes of the body of every error response
+// are copied into its HTTPError. The response body still reads in full afterwards.
+//
+// The zero value, and a nil *HTTPClassifier, classify with the default table alone.
+type HTTPClassifier struct {
+	Codes       map[int]HTTPDecision
+	Ranges      []HTTPRange
+	Custom      func(r *http.Response) (HTTPDecision, bool)
+	BodySnippet int
+}
+
+// DefaultHTTPClassifier is used by WrapHttpResponseError.
+var DefaultHTTPClassifier = &HTTPClassifier{}
+
+// Classify classifies the result of an HTTP call. It takes and returns the response so
+// that it can wrap a call directly, as in c.Classify(http.Get(url)).
+func (c *HTTPClassifier) Classify(r *http.Response, err error) (*http.Response, ClientError) {
+	if r == nil && err == nil {
+		return nil, nil
+	} else if err != nil {
+		return r, RetriableError{E: err}
+	}
+
+	d := c.Decide(r)
+	if d == HTTPSuccess {
+		return r, nil
+	}
+	he := newHTTPError(r)
+	if c != nil && c.BodySnippet > 0 {
+		he.Body = snippet(r, c.BodySnippet)
+	}
+	switch d {
+	case HTTPRetriable:
+		he.RetryAfter, _ = parseRetryAfter(r, time.Now())
+		return r, RetriableError{E: he}
+	case HTTPThrottled:
+		if after, ok := parseRetryAfter(r, time.Now()); ok {
+			he.RetryAfter = after
+			return r, RetriableError{E: he}
+		}
+		return r, NonRetriableError{E: he}
+	}
+	return r, NonRetriableError{E: he}
+}
+
+// Decide returns the decision for r without reading its Retry-After header.
+func (c *HTTPClassifier) Decide(r *http.Response) HTTPDecision {
+	if c != nil {
+		if c.Custom != nil {
+			if d, ok := c.Custom(r); ok {
+				return d
+			}
+		}
+		if d, ok := c.Codes[r.StatusCode]; ok {
+			return d
+		}
+		for i := len(c.Ranges) - 1; i >= 0; i-- {
+			if rg := c.Ranges[i]; r.StatusCode >= rg.From && r.StatusCode <= rg.To {
+				return rg.Decision
+			}
+		}
+	}
+	return defaultHTTPDecisions[r.StatusCode]
+}
+
+var defaultHTTPDecisions = map[int]HTTPDecision{
+	http.StatusBadRequest:                   HTTPNonRetriable,
+	http.StatusUnauthorized:                 HTTPNonRetriable,
+	http.StatusPaymentRequired:              HTTPNonRetriable,
+	http.StatusForbidden:                    HTTPNonRetriable,
+	http.StatusNotFound:                     HTTPNonRetriable,
+	http.StatusMethodNotAllowed:             HTTPNonRetriable,
+	http.StatusNotAcceptable:                HTTPNonRetriable,
+	http.StatusProxyAuthRequired:            HTTPNonRetriable,
+	http.StatusRequestTimeout:               HTTPNonRetriable,
+	http.StatusConflict:                     HTTPNonRetriable,
+	http.StatusGone:                         HTTPNonRetriable,
+	http.StatusLengthRequired:               HTTPNonRetriable,
+	http.StatusPreconditionFailed:           HTTPNonRetriable,
+	http.StatusRequestEntityTooLarge:        HTTPNonRetriable,
+	http.StatusRequestURITooLong:            HTTPNonRetriable,
+	http.StatusUnsupportedMediaType:         HTTPNonRetriable,
+	http.StatusRequestedRangeNotSatisfiable: HTTPNonRetriable,
+	http.StatusExpectationFailed:            HTTPNonRetriable,
+	http.StatusTeapot:                       HTTPNonRetriable,
+	http.StatusUnprocessableEntity:          HTTPNonRetriable,
+	http.StatusLocked:                       HTTPNonRetriable,
+	http.StatusFailedDependency:             HTTPNonRetriable,
+	http.StatusUpgradeRequired:              HTTPNonRetriable,
+	http.StatusPreconditionRequired:         HTTPNonRetriable,
+	http.StatusTooManyRequests:              HTTPThrottled,
+	http.StatusRequestHeaderFieldsTooLarge:  HTTPNonRetriable,
+	http.StatusUnavailableForLegalReasons:   HTTPNonRetriable,
+
+	http.StatusInternalServerError:           HTTPRetriable,
+	http.StatusNotImplemented:                HTTPRetriable,
+	http.StatusBadGateway:                    HTTPRetriable,
+	http.StatusServiceUnavailable:            HTTPRetriable,
+	http.StatusGatewayTimeout:                HTTPRetriable,
+	http.StatusHTTPVersionNotSupported:       HTTPRetriable,
+	http.StatusVariantAlsoNegotiates:         HTTPRetriable,
+	http.StatusInsufficientStorage:           HTTPRetriable,
+	http.StatusLoopDetected:                  HTTPRetriable,
+	http.StatusNotExtended:                   HTTPRetriable,
+	http.StatusNetworkAuthenticationRequired: HTTPRetriable,
+}
+
+func newHTTPError(r *http.Response) HTTPError {
+	return HTTPError{StatusCode: r.StatusCode, Status: r.Status}
+}
+
+// snippet reads up to n bytes from the start of the body of r and puts them back in front
+// of the rest of it.
+func snippet(r *http.Response, n int) string {
+	if r.Body == nil {
+		return ``
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(r.Body, int64(n)))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+	return string(b)
+}
+
+// maxDrain bounds how much of a discarded response body is read so that its connection
+// can be reused.
+const maxDrain = 64 << 10
+
+// DrainResponse reads and discards up to 64KB of the body of r, so that its connection can
+// go back to the keep-alive pool, and closes it.
+func DrainResponse(r *http.Response) {
+	if r == nil || r.Body == nil {
+		return
+	}
+	io.CopyN(ioutil.Discard, r.Body, maxDrain)
+	r.Body.Close()
+}
+
+// Discarder is implemented by attempt results that hold resources. Retry calls Discard on
+// every result returned by an attempt that is superseded by a later one.
+type Discarder interface {
+	Discard()
+}
+
+// releaseOnClose arranges for release to be called when the body of an *http.Response
+// result is closed rather than when the attempt returns, so that the body can still be
+// read. It reports whether it did.
+func releaseOnClose(result interface{}, release func()) bool {
+	r, ok := result.(*http.Response)
+	if !ok || r == nil || r.Body == nil || r.Body == http.NoBody {
+		return false
+	}
+	r.Body = &releasingBody{ReadCloser: r.Body, release: release}
+	return true
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// settle frees the connection behind the body of an *http.Response result before the
+// retry loop waits, when the body is no longer than 64KB. Such a body is read into memory
+// and closed, so the response still reads in full if the loop gives up and returns it.
+// A longer body is left open, to be discarded once a later attempt supersedes it.
+func settle(result interface{}) {
+	r, ok := result.(*http.Response)
+	if !ok || r == nil || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxDrain+1))
+	if err == nil && len(b) <= maxDrain {
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		return
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+}
+
+// discard releases the resources held by a superseded attempt result.
+func discard(result interface{}) {
+	switch r := result.(type) {
+	case *http.Response:
+		DrainResponse(r)
+	case Discarder:
+		r.Discard()
+	}
+}
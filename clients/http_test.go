@@ -0,0 +1,153 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHTTPClassifierDefaults(t *testing.T) {
+	var zero HTTPClassifier
+	var nilClassifier *HTTPClassifier
+	expected := map[int]HTTPDecision{
+		http.StatusOK:                  HTTPSuccess,
+		http.StatusFound:               HTTPSuccess,
+		http.StatusRequestTimeout:      HTTPNonRetriable,
+		http.StatusTooManyRequests:     HTTPThrottled,
+		http.StatusNotFound:            HTTPNonRetriable,
+		http.StatusNotImplemented:      HTTPRetriable,
+		http.StatusServiceUnavailable:  HTTPRetriable,
+		http.StatusInternalServerError: HTTPRetriable,
+		http.StatusTeapot:              HTTPNonRetriable,
+		http.StatusMisdirectedRequest:  HTTPSuccess,
+		http.StatusTooEarly:            HTTPSuccess,
+		http.StatusLoopDetected:        HTTPRetriable,
+	}
+	for code, d := range expected {
+		r := &http.Response{StatusCode: code}
+		if got := zero.Decide(r); got != d {
+			t.Fatalf(`Zero classifier decided %v for %v instead of %v`, got, code, d)
+		}
+		if got := nilClassifier.Decide(r); got != d {
+			t.Fatalf(`Nil classifier decided %v for %v instead of %v`, got, code, d)
+		}
+	}
+}
+
+func TestHTTPClassifierOverrides(t *testing.T) {
+	c := &HTTPClassifier{
+		Codes: map[int]HTTPDecision{
+			http.StatusRequestTimeout:  HTTPRetriable,
+			http.StatusTooManyRequests: HTTPRetriable,
+			http.StatusNotImplemented:  HTTPNonRetriable,
+		},
+		Ranges: []HTTPRange{
+			{From: 300, To: 399, Decision: HTTPNonRetriable},
+			{From: 500, To: 599, Decision: HTTPNonRetriable},
+			{From: 502, To: 504, Decision: HTTPRetriable},
+		},
+		Custom: func(r *http.Response) (HTTPDecision, bool) {
+			if r.Header.Get(`X-Retry`) == `yes` {
+				return HTTPRetriable, true
+			}
+			return HTTPSuccess, false
+		},
+	}
+	cases := []struct {
+		code      int
+		header    string
+		retriable bool
+	}{
+		{http.StatusRequestTimeout, ``, true},
+		{http.StatusTooManyRequests, ``, true},
+		{http.StatusNotImplemented, ``, false},
+		{http.StatusMovedPermanently, ``, false},
+		{http.StatusInternalServerError, ``, false},
+		{http.StatusBadGateway, ``, true},
+		{http.StatusNotFound, ``, false},
+		{http.StatusNotFound, `yes`, true},
+	}
+	for _, tc := range cases {
+		r := &http.Response{StatusCode: tc.code, Header: http.Header{}}
+		if tc.header != `` {
+			r.Header.Set(`X-Retry`, tc.header)
+		}
+		rr, e := c.Classify(r, nil)
+		if rr != r {
+			t.Fatalf(`%v failed to return the original response`, tc.code)
+		}
+		if e == nil {
+			t.Fatalf(`%v was not an error`, tc.code)
+		}
+		if e.IsRetriable() != tc.retriable {
+			t.Fatalf(`%v with X-Retry %q was retriable: %v`, tc.code, tc.header, e.IsRetriable())
+		}
+		var he HTTPError
+		if !errors.As(e, &he) || he.StatusCode != tc.code {
+			t.Fatalf(`%v did not carry an HTTPError`, tc.code)
+		}
+	}
+
+	if _, e := c.Classify(&http.Response{StatusCode: http.StatusOK}, nil); e != nil {
+		t.Fatal(`Successful response resulted in a non-nil err`)
+	}
+	if _, e := c.Classify(nil, errors.New(`Junk error`)); e == nil || !e.IsRetriable() {
+		t.Fatal(`Transport error was not retriable`)
+	}
+}
+
+func TestHTTPClassifierBodySnippet(t *testing.T) {
+	c := &HTTPClassifier{BodySnippet: 5}
+	r := &http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Body:       ioutil.NopCloser(strings.NewReader(`database unavailable`)),
+	}
+	_, e := c.Classify(r, nil)
+	var he HTTPError
+	if !errors.As(e, &he) || he.Body != `datab` {
+		t.Fatalf(`Expected a truncated body snippet, got %q`, he.Body)
+	}
+	if !strings.Contains(e.Error(), `datab`) {
+		t.Fatal(`Error message did not include the body snippet`)
+	}
+	if b, _ := ioutil.ReadAll(r.Body); string(b) != `database unavailable` {
+		t.Fatalf(`Capturing a snippet consumed the body, %q was left`, b)
+	}
+}
+
+func TestRetryDrainsSupersededResponses(t *testing.T) {
+	var bodies []*trackedBody
+	f := func() (interface{}, ClientError) {
+		b := &trackedBody{Reader: strings.NewReader(`unavailable`)}
+		bodies = append(bodies, b)
+		return WrapHttpResponseError(&http.Response{StatusCode: http.StatusServiceUnavailable, Body: b}, nil)
+	}
+	r, _ := RetryN(f, 3, fastWaiter())
+	if len(bodies) != 3 {
+		t.Fatalf(`Expected 3 attempts, got %v`, len(bodies))
+	}
+	for i, b := range bodies[:2] {
+		if !b.closed || b.Len() != 0 {
+			t.Fatalf(`Superseded response %v was not drained and closed`, i+1)
+		}
+	}
+	if b, err := ioutil.ReadAll(r.(*http.Response).Body); err != nil || string(b) != `unavailable` {
+		t.Fatalf(`The last response was not returned intact, got %q, %v`, b, err)
+	}
+}
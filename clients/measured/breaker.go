@@ -0,0 +1,38 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package measured
+
+import (
+	"github.com/buildertools/svctools-go/clients"
+)
+
+// BreakerCollectors count circuit breaker state transitions by the state entered. Use
+// OnStateChange as the OnStateChange hook of a clients.Breaker. Any of them may be nil.
+type BreakerCollectors struct {
+	Opened     Meter
+	HalfOpened Meter
+	Closed     Meter
+}
+
+func (b BreakerCollectors) OnStateChange(from, to clients.BreakerState) {
+	switch to {
+	case clients.BreakerOpen:
+		mark(b.Opened)
+	case clients.BreakerHalfOpen:
+		mark(b.HalfOpened)
+	case clients.BreakerClosed:
+		mark(b.Closed)
+	}
+}
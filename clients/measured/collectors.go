@@ -0,0 +1,68 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package measured
+
+import (
+	"github.com/rcrowley/go-metrics"
+)
+
+// NewCollectors returns Collectors backed by metrics in r, which defaults to
+// metrics.DefaultRegistry. Every metric is named after prefix, a dot and what it measures,
+// for example "someawesomeservice.attempt.time". Metrics that are already registered
+// under those names are reused, so calling NewCollectors again with the same prefix
+// returns Collectors that share the same metrics. The labeled Failures and Outcomes keep
+// at most DefaultSeriesLimit series each in r, however many times NewCollectors is called.
+//
+// The deprecated Error and Fatal collectors are left nil.
+func NewCollectors(r metrics.Registry, prefix string) Collectors {
+	if r == nil {
+		r = metrics.DefaultRegistry
+	}
+	name := func(n string) string {
+		if prefix == "" {
+			return n
+		}
+		return prefix + "." + n
+	}
+	return Collectors{
+		Attempt:             metrics.GetOrRegisterMeter(name("attempt"), r),
+		TotalTime:           registerTimer(name("total.time"), r),
+		AttemptTime:         registerTimer(name("attempt.time"), r),
+		SuccessFirstTry:     metrics.GetOrRegisterMeter(name("success.first_try"), r),
+		SuccessAfterRetry:   metrics.GetOrRegisterMeter(name("success.after_retry"), r),
+		RetriableFailure:    metrics.GetOrRegisterMeter(name("failure.retriable"), r),
+		NonRetriableFailure: metrics.GetOrRegisterMeter(name("failure.non_retriable"), r),
+		Exhausted:           metrics.GetOrRegisterMeter(name("exhausted"), r),
+		Cancelled:           metrics.GetOrRegisterMeter(name("cancelled"), r),
+		BackoffTime:         registerTimer(name("backoff.time"), r),
+		Attempts:            registerHistogram(name("attempts"), r),
+		Failures:            NewMeterVec(r, name("failures"), DefaultSeriesLimit),
+		Outcomes:            NewMeterVec(r, name("outcomes"), DefaultSeriesLimit),
+	}
+}
+
+// registerTimer returns the timer registered in r under name, registering a NewSummedTimer if
+// there is none.
+func registerTimer(name string, r metrics.Registry) metrics.Timer {
+	return r.GetOrRegister(name, NewSummedTimer).(metrics.Timer)
+}
+
+// registerHistogram returns the histogram registered in r under name, registering a
+// NewSummedHistogram if there is none.
+func registerHistogram(name string, r metrics.Registry) metrics.Histogram {
+	return r.GetOrRegister(name, func() metrics.Histogram {
+		return NewSummedHistogram(metrics.NewExpDecaySample(1028, 0.015))
+	}).(metrics.Histogram)
+}
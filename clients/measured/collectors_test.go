@@ -0,0 +1,107 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package measured
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildertools/svctools-go/clients"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestNewCollectors(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := NewCollectors(r, "someawesomeservice")
+	if c.Error != nil || c.Fatal != nil {
+		t.Fatal(`Expected the deprecated collectors to be left nil`)
+	}
+	for _, n := range []string{
+		"attempt", "total.time", "attempt.time", "success.first_try", "success.after_retry",
+		"failure.retriable", "failure.non_retriable", "exhausted", "cancelled", "backoff.time", "attempts",
+	} {
+		if r.Get("someawesomeservice."+n) == nil {
+			t.Fatalf(`Expected someawesomeservice.%v to be registered`, n)
+		}
+	}
+
+	for _, m := range []interface{}{c.TotalTime, c.AttemptTime, c.BackoffTime, c.Attempts} {
+		if _, ok := m.(Totaler); !ok {
+			t.Fatalf(`Expected %T to keep a running total`, m)
+		}
+	}
+
+	_, err := Retry(failing(1, clients.Retriable(errors.New(`retriable`))),
+		&clients.JitteredBackoff{TTL: time.Minute, Bof: clients.NoBackoff, Jf: clients.NoJitter},
+		c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a second call shares the metrics of the first
+	again := NewCollectors(r, "someawesomeservice")
+	if n := again.Attempt.(metrics.Meter).Count(); n != 2 {
+		t.Fatalf(`Expected to reuse the attempt meter with 2 marks, got %v`, n)
+	}
+	if n := again.SuccessAfterRetry.(metrics.Meter).Count(); n != 1 {
+		t.Fatalf(`Expected to reuse the success meter with 1 mark, got %v`, n)
+	}
+	if n := again.Attempts.(metrics.Histogram).Max(); n != 2 {
+		t.Fatalf(`Expected the attempts histogram to record 2, got %v`, n)
+	}
+
+	if n := r.Get(`someawesomeservice.failures{retriable="true",status="none"}`).(metrics.Meter).Count(); n != 1 {
+		t.Fatalf(`Expected one labeled failure, got %v`, n)
+	}
+	if n := r.Get(`someawesomeservice.outcomes{outcome="success"}`).(metrics.Meter).Count(); n != 1 {
+		t.Fatalf(`Expected one labeled success, got %v`, n)
+	}
+
+	if NewCollectors(r, "").Exhausted != r.Get("exhausted") {
+		t.Fatal(`Expected unprefixed names without a prefix`)
+	}
+}
+
+func TestNewCollectorsSeriesLimit(t *testing.T) {
+	r := metrics.NewRegistry()
+	for i := 0; i < 300; i++ {
+		NewCollectors(r, "svc").Failures.With(Labels{"status": strconv.Itoa(i)}).Mark(1)
+	}
+	series := 0
+	r.Each(func(name string, _ interface{}) {
+		if strings.HasPrefix(name, "svc.failures{") {
+			series++
+		}
+	})
+	if series != DefaultSeriesLimit {
+		t.Fatalf(`Expected %v failure series across calls, got %v`, DefaultSeriesLimit, series)
+	}
+	if n := r.Get(`svc.failures{overflow="other"}`).(metrics.Meter).Count(); n != 300-DefaultSeriesLimit+1 {
+		t.Fatalf(`Expected %v marks in the overflow series, got %v`, 300-DefaultSeriesLimit+1, n)
+	}
+}
+
+func TestRetryNilCollectors(t *testing.T) {
+	_, err := RetryN(failing(5, clients.Retriable(errors.New(`retriable`))), 2,
+		&clients.JitteredBackoff{TTL: time.Minute, Bof: clients.NoBackoff, Jf: clients.NoJitter},
+		Collectors{})
+	if err == nil {
+		t.Fatal(`Expected to give up`)
+	}
+	BreakerCollectors{}.OnStateChange(clients.BreakerClosed, clients.BreakerOpen)
+}
@@ -0,0 +1,37 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package measured
+
+import (
+	"context"
+	"github.com/buildertools/svctools-go/clients"
+)
+
+// RetryT is the instrumented equivalent of clients.RetryT.
+func RetryT[T any](f func() (T, error), pw clients.PerishableWaiter, c Collectors, obs ...clients.Observer) (T, error) {
+	return RetryContextT(context.Background(), func(context.Context) (T, error) {
+		return f()
+	}, pw, c, obs...)
+}
+
+// RetryContextT is the instrumented equivalent of clients.RetryContextT.
+func RetryContextT[T any](ctx context.Context, f func(ctx context.Context) (T, error), pw clients.PerishableWaiter, c Collectors, obs ...clients.Observer) (T, error) {
+	return clients.Typed[T](RetryContext(ctx, clients.Untyped(f), pw, c, obs...))
+}
+
+// RetryNT is the instrumented equivalent of clients.RetryNT.
+func RetryNT[T any](f func() (T, error), attempts uint, pw clients.PerishableWaiter, c Collectors) (T, error) {
+	return RetryT(f, &clients.MaxAttempts{N: attempts, Waiter: pw}, c)
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package measured
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Labels are the dimensions that tell the series of a labeled metric apart.
+type Labels map[string]string
+
+// MeterVec is a family of meters with one series for every distinct set of labels.
+type MeterVec interface {
+	With(Labels) Meter
+}
+
+// TimerVec is a family of timers with one series for every distinct set of labels.
+type TimerVec interface {
+	With(Labels) Timer
+}
+
+// CounterVec is a family of counters with one series for every distinct set of labels.
+type CounterVec interface {
+	With(Labels) Counter
+}
+
+// DefaultSeriesLimit is the number of series a labeled metric keeps when it is given a
+// limit of zero.
+const DefaultSeriesLimit = 100
+
+// Overflow is the value of the overflow label of the series that collects measurements
+// once a labeled metric has reached its limit.
+const Overflow = "other"
+
+// overflowKey labels the overflow series of every labeled metric.
+const overflowKey = `{overflow="` + Overflow + `"}`
+
+// NewMeterVec returns a MeterVec that registers a go-metrics meter in r for each set of
+// labels it is given. Series are named after name and their labels in the Prometheus
+// style, for example someawesomeservice.outcomes{outcome="expired"}, which
+// PrometheusHandler understands.
+//
+// At most limit series are registered, one of them kept for overflow. Labels seen once
+// the others are taken share the overflow series, which has the single label
+// overflow="other", so the registry stays bounded however many distinct label values show
+// up. The limit counts the series already in r, so it holds across every MeterVec made
+// with the same registry and name.
+func NewMeterVec(r metrics.Registry, name string, limit int) MeterVec {
+	return meterVec{newVec(r, name, limit, func(n string) interface{} {
+		return metrics.GetOrRegisterMeter(n, r)
+	})}
+}
+
+// NewTimerVec is the TimerVec equivalent of NewMeterVec.
+func NewTimerVec(r metrics.Registry, name string, limit int) TimerVec {
+	return timerVec{newVec(r, name, limit, func(n string) interface{} {
+		return registerTimer(n, r)
+	})}
+}
+
+// NewCounterVec is the CounterVec equivalent of NewMeterVec.
+func NewCounterVec(r metrics.Registry, name string, limit int) CounterVec {
+	return counterVec{newVec(r, name, limit, func(n string) interface{} {
+		return metrics.GetOrRegisterCounter(n, r)
+	})}
+}
+
+type meterVec struct{ *vec }
+
+func (v meterVec) With(l Labels) Meter {
+	return v.with(l).(Meter)
+}
+
+type timerVec struct{ *vec }
+
+func (v timerVec) With(l Labels) Timer {
+	return v.with(l).(Timer)
+}
+
+type counterVec struct{ *vec }
+
+func (v counterVec) With(l Labels) Counter {
+	return v.with(l).(Counter)
+}
+
+// vec tracks the series of a labeled metric.
+type vec struct {
+	r        metrics.Registry
+	name     string
+	limit    int
+	create   func(name string) interface{}
+	mu       sync.Mutex
+	series   map[string]interface{}
+	full     bool
+	overflow interface{}
+}
+
+func newVec(r metrics.Registry, name string, limit int, create func(string) interface{}) *vec {
+	if r == nil {
+		r = metrics.DefaultRegistry
+	}
+	if limit <= 0 {
+		limit = DefaultSeriesLimit
+	}
+	return &vec{r: r, name: name, limit: limit, create: create, series: map[string]interface{}{}}
+}
+
+// registering serializes counting and registering series, so that vecs sharing a
+// registry and name keep to one limit.
+var registering sync.Mutex
+
+func (v *vec) with(l Labels) interface{} {
+	key := encodeLabels(l)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if m, ok := v.series[key]; ok {
+		return m
+	}
+	registering.Lock()
+	defer registering.Unlock()
+	// the last series is kept for overflow
+	if v.r.Get(v.name+key) == nil && (v.full || v.registered() >= v.limit-1) {
+		v.full = true
+		if v.overflow == nil {
+			v.overflow = v.create(v.name + overflowKey)
+		}
+		return v.overflow
+	}
+	m := v.create(v.name + key)
+	v.series[key] = m
+	return m
+}
+
+// registered counts the labeled series of the metric in the registry, leaving out the
+// overflow series.
+func (v *vec) registered() int {
+	n := 0
+	v.r.Each(func(name string, _ interface{}) {
+		if strings.HasPrefix(name, v.name+"{") && name != v.name+overflowKey {
+			n++
+		}
+	})
+	return n
+}
+
+// encodeLabels formats l in the Prometheus style with sorted, sanitized names and escaped
+// values. Empty labels format as an empty string.
+func encodeLabels(l Labels) string {
+	if len(l) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(l))
+	for k := range l {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strings.Replace(prometheusName(k), ":", "_", -1))
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(l[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,66 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package measured
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestEncodeLabels(t *testing.T) {
+	if l := encodeLabels(nil); l != `` {
+		t.Fatalf(`Expected no labels, got %v`, l)
+	}
+	if l := encodeLabels(Labels{"b": "2", "a": "x\"y\\z\n", "c.d": ""}); l != `{a="x\"y\\z\n",b="2",c_d=""}` {
+		t.Fatalf(`Unexpected labels %v`, l)
+	}
+}
+
+func TestMeterVecLimit(t *testing.T) {
+	r := metrics.NewRegistry()
+	v := NewMeterVec(r, "svc.failures", 3)
+	for i := 0; i < 10; i++ {
+		v.With(Labels{"status": strconv.Itoa(500 + i)}).Mark(1)
+	}
+	// the same labels get the same series
+	v.With(Labels{"status": "500"}).Mark(1)
+
+	if n := r.Get(`svc.failures{status="500"}`).(metrics.Meter).Count(); n != 2 {
+		t.Fatalf(`Expected 2 marks for status 500, got %v`, n)
+	}
+	if r.Get(`svc.failures{status="502"}`) != nil {
+		t.Fatal(`Registered a series past the limit`)
+	}
+	// labels with other names share the same overflow series
+	v.With(Labels{"code": "x"}).Mark(1)
+	if n := r.Get(`svc.failures{overflow="other"}`).(metrics.Meter).Count(); n != 9 {
+		t.Fatalf(`Expected 9 marks in the overflow series, got %v`, n)
+	}
+	count := 0
+	r.Each(func(string, interface{}) { count++ })
+	if count != 3 {
+		t.Fatalf(`Expected 2 series and an overflow, got %v`, count)
+	}
+
+	if NewTimerVec(r, "svc.wait", 0).With(nil) != r.Get("svc.wait") {
+		t.Fatal(`Expected a series without labels to use the bare name`)
+	}
+	NewCounterVec(r, "svc.inflight", 0).With(Labels{"a": "b"}).Inc(1)
+	if r.Get(`svc.inflight{a="b"}`) == nil {
+		t.Fatal(`Expected a labeled counter`)
+	}
+}
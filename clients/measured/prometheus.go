@@ -0,0 +1,246 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package measured
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// PrometheusQuantiles are the quantiles reported for timers and histograms.
+var PrometheusQuantiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+// PrometheusHandler returns an http.Handler that serves the metrics in r, which defaults
+// to metrics.DefaultRegistry, in the Prometheus text exposition format.
+func PrometheusHandler(r metrics.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var b bytes.Buffer
+		if err := WritePrometheus(&b, r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(b.Bytes())
+	})
+}
+
+// WritePrometheus writes the metrics in r, which defaults to metrics.DefaultRegistry, to w
+// in the Prometheus text exposition format. Metric names are sanitized by replacing every
+// character Prometheus does not allow with an underscore. A name that ends in labels, such
+// as the series of a MeterVec, is written as a labeled series of its family. Every sample
+// name, including the _sum and _count of a summary, belongs to the first family in lexical
+// order that writes it, and only the first metric in lexical order is written for each set
+// of labels. Metrics that would break those rules are left out.
+//
+// Counters and gauges are written as gauges. Meters are counters named with a _total
+// suffix. Timers and histograms are summaries with PrometheusQuantiles, a _count and a
+// _sum. Timers are in seconds and named with a _seconds suffix. Quantiles come from the
+// metric's sample. go-metrics does not keep a running total, so the _sum is only written
+// for metrics that implement Totaler, such as those made by NewCollectors.
+func WritePrometheus(w io.Writer, r metrics.Registry) error {
+	if r == nil {
+		r = metrics.DefaultRegistry
+	}
+	var ss []series
+	r.Each(func(name string, i interface{}) {
+		base, labels := splitLabels(name)
+		n := prometheusName(base)
+		switch i.(type) {
+		case metrics.Counter, metrics.Gauge, metrics.GaugeFloat64:
+			ss = append(ss, series{name, n, labels, "gauge", i})
+		case metrics.Meter:
+			ss = append(ss, series{name, n + "_total", labels, "counter", i})
+		case metrics.Timer:
+			ss = append(ss, series{name, n + "_seconds", labels, "summary", i})
+		case metrics.Histogram:
+			ss = append(ss, series{name, n, labels, "summary", i})
+		}
+	})
+	sort.Slice(ss, func(i, j int) bool {
+		if ss[i].family != ss[j].family {
+			return ss[i].family < ss[j].family
+		}
+		if ss[i].labels != ss[j].labels {
+			return ss[i].labels < ss[j].labels
+		}
+		return ss[i].name < ss[j].name
+	})
+
+	bw := bufio.NewWriter(w)
+	owners := map[string]string{}
+	kinds := map[string]string{}
+	written := map[string]bool{}
+	for _, s := range ss {
+		if k, ok := kinds[s.family]; (ok && k != s.kind) || written[s.family+s.labels] || !s.claim(owners) {
+			continue
+		}
+		if _, ok := kinds[s.family]; !ok {
+			kinds[s.family] = s.kind
+			fmt.Fprintf(bw, "# TYPE %s %s\n", s.family, s.kind)
+		}
+		written[s.family+s.labels] = true
+		switch m := s.metric.(type) {
+		case metrics.Counter:
+			writeSample(bw, s.family, s.labels, float64(m.Count()))
+		case metrics.Gauge:
+			writeSample(bw, s.family, s.labels, float64(m.Value()))
+		case metrics.GaugeFloat64:
+			writeSample(bw, s.family, s.labels, m.Value())
+		case metrics.Meter:
+			writeSample(bw, s.family, s.labels, float64(m.Count()))
+		case metrics.Timer:
+			t := m.Snapshot()
+			writeSummary(bw, s.family, s.labels, t.Count(), m, t.Percentiles(PrometheusQuantiles), 1e-9)
+		case metrics.Histogram:
+			h := m.Snapshot()
+			writeSummary(bw, s.family, s.labels, h.Count(), m, h.Percentiles(PrometheusQuantiles), 1)
+		}
+	}
+	return bw.Flush()
+}
+
+// series is a metric of a registry on its way to Prometheus.
+type series struct {
+	name   string
+	family string
+	labels string
+	kind   string
+	metric interface{}
+}
+
+// claim reports whether the sample names s writes are free or already belong to its
+// family, and claims them for its family if so.
+func (s series) claim(owners map[string]string) bool {
+	names := []string{s.family}
+	if s.kind == "summary" {
+		names = append(names, s.family+"_sum", s.family+"_count")
+	}
+	for _, n := range names {
+		if o, ok := owners[n]; ok && o != s.family {
+			return false
+		}
+	}
+	for _, n := range names {
+		owners[n] = s.family
+	}
+	return true
+}
+
+// splitLabels splits a name like base{a="b"} into its base and its labels. A name whose
+// braces do not hold well formed labels is returned whole, to be sanitized like any other.
+func splitLabels(name string) (string, string) {
+	i := strings.IndexByte(name, '{')
+	if i < 0 || !strings.HasSuffix(name, "}") || !validLabels(name[i+1:len(name)-1]) {
+		return name, ""
+	}
+	return name[:i], name[i:]
+}
+
+// validLabels reports whether s is a comma separated list of name="value" pairs with
+// valid names and escaped values, as written by encodeLabels.
+func validLabels(s string) bool {
+	for s != "" {
+		eq := strings.Index(s, `="`)
+		if eq <= 0 || prometheusName(s[:eq]) != s[:eq] || strings.IndexByte(s[:eq], ':') >= 0 {
+			return false
+		}
+		s = s[eq+2:]
+		for {
+			if s == "" || s[0] == '\n' {
+				return false
+			}
+			if s[0] == '"' {
+				break
+			}
+			if s[0] == '\\' {
+				if len(s) < 2 || !strings.ContainsRune(`\"n`, rune(s[1])) {
+					return false
+				}
+				s = s[1:]
+			}
+			s = s[1:]
+		}
+		s = s[1:]
+		if s != "" {
+			if s[0] != ',' || len(s) == 1 {
+				return false
+			}
+			s = s[1:]
+		}
+	}
+	return true
+}
+
+func writeSample(w io.Writer, name, labels string, v float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(v))
+}
+
+// writeSummary writes a summary of values that are converted to the base unit by scale.
+// The _sum is written if metric is a Totaler.
+func writeSummary(w io.Writer, name, labels string, count int64, metric interface{}, ps []float64, scale float64) {
+	for i, q := range PrometheusQuantiles {
+		fmt.Fprintf(w, "%s%s %s\n", name, withLabel(labels, "quantile", formatFloat(q)), formatFloat(ps[i]*scale))
+	}
+	if t, ok := metric.(Totaler); ok {
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(float64(t.Total())*scale))
+	}
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, count)
+}
+
+// withLabel adds the label k="v" to labels.
+func withLabel(labels, k, v string) string {
+	l := k + `="` + v + `"`
+	if labels == "" {
+		return "{" + l + "}"
+	}
+	return labels[:len(labels)-1] + "," + l + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// prometheusName replaces the characters of name that are not allowed in a Prometheus
+// metric name with underscores.
+func prometheusName(name string) string {
+	n := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		}
+		return '_'
+	}, name)
+	if n == "" || (n[0] >= '0' && n[0] <= '9') {
+		n = "_" + n
+	}
+	return n
+}
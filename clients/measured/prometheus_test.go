@@ -0,0 +1,190 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package measured
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("some.counter", r).Inc(3)
+	metrics.GetOrRegisterGauge("some-gauge", r).Update(7)
+	metrics.GetOrRegisterGaugeFloat64("1gauge", r).Update(0.25)
+	metrics.GetOrRegisterMeter("svc.attempt", r).Mark(2)
+	tm := metrics.GetOrRegisterTimer("svc.attempt.time", r)
+	tm.Update(time.Duration(1) * time.Second)
+	tm.Update(time.Duration(3) * time.Second)
+	h := metrics.GetOrRegisterHistogram("svc.attempts", r, metrics.NewUniformSample(100))
+	h.Update(1)
+	h.Update(3)
+	// sanitizes to the same name as svc.attempts and is dropped
+	metrics.GetOrRegisterCounter("svc_attempts", r).Inc(1)
+
+	var b strings.Builder
+	if err := WritePrometheus(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE _1gauge gauge
+_1gauge 0.25
+# TYPE some_counter gauge
+some_counter 3
+# TYPE some_gauge gauge
+some_gauge 7
+# TYPE svc_attempt_time_seconds summary
+svc_attempt_time_seconds{quantile="0.5"} 2
+svc_attempt_time_seconds{quantile="0.75"} 3
+svc_attempt_time_seconds{quantile="0.95"} 3
+svc_attempt_time_seconds{quantile="0.99"} 3
+svc_attempt_time_seconds{quantile="0.999"} 3
+svc_attempt_time_seconds_count 2
+# TYPE svc_attempt_total counter
+svc_attempt_total 2
+# TYPE svc_attempts summary
+svc_attempts{quantile="0.5"} 2
+svc_attempts{quantile="0.75"} 3
+svc_attempts{quantile="0.95"} 3
+svc_attempts{quantile="0.99"} 3
+svc_attempts{quantile="0.999"} 3
+svc_attempts_count 2
+`
+	if got := b.String(); got != want {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestWritePrometheusCollisions(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterMeter("a", r).Mark(1)
+	metrics.GetOrRegisterCounter("a_total", r).Inc(2)
+	metrics.GetOrRegisterHistogram("x", r, metrics.NewUniformSample(100)).Update(5)
+	metrics.GetOrRegisterGauge("x_count", r).Update(3)
+	metrics.GetOrRegisterGauge(`x_sum{k="v"}`, r).Update(4)
+
+	var b strings.Builder
+	if err := WritePrometheus(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE a_total counter
+a_total 1
+# TYPE x summary
+x{quantile="0.5"} 5
+x{quantile="0.75"} 5
+x{quantile="0.95"} 5
+x{quantile="0.99"} 5
+x{quantile="0.999"} 5
+x_count 1
+`
+	if got := b.String(); got != want {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestWritePrometheusSum(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := NewSummedHistogram(metrics.NewUniformSample(10))
+	r.Register("h", h)
+	sum := func() string {
+		var b strings.Builder
+		if err := WritePrometheus(&b, r); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range strings.Split(b.String(), "\n") {
+			if strings.HasPrefix(l, "h_sum ") {
+				return strings.TrimPrefix(l, "h_sum ")
+			}
+		}
+		t.Fatal(`No h_sum was written`)
+		return ""
+	}
+	// the sum keeps counting once the sample is full
+	for i := 0; i < 50; i++ {
+		h.Update(1000)
+	}
+	if got := sum(); got != "50000" {
+		t.Fatalf(`Expected a sum of 50000, got %v`, got)
+	}
+	for i := 0; i < 20; i++ {
+		h.Update(1)
+	}
+	if got := sum(); got != "50020" {
+		t.Fatalf(`Expected a sum of 50020, got %v`, got)
+	}
+}
+
+func TestWritePrometheusLabels(t *testing.T) {
+	r := metrics.NewRegistry()
+	outcomes := NewMeterVec(r, "svc.outcomes", 0)
+	outcomes.With(Labels{"outcome": "success"}).Mark(2)
+	outcomes.With(Labels{"outcome": "expired"}).Mark(1)
+	NewTimerVec(r, "svc.wait", 0).With(Labels{"reason": `say "hi"`}).Update(time.Duration(2) * time.Second)
+
+	var b strings.Builder
+	if err := WritePrometheus(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE svc_outcomes_total counter
+svc_outcomes_total{outcome="expired"} 1
+svc_outcomes_total{outcome="success"} 2
+# TYPE svc_wait_seconds summary
+svc_wait_seconds{reason="say \"hi\"",quantile="0.5"} 2
+svc_wait_seconds{reason="say \"hi\"",quantile="0.75"} 2
+svc_wait_seconds{reason="say \"hi\"",quantile="0.95"} 2
+svc_wait_seconds{reason="say \"hi\"",quantile="0.99"} 2
+svc_wait_seconds{reason="say \"hi\"",quantile="0.999"} 2
+svc_wait_seconds_sum{reason="say \"hi\""} 2
+svc_wait_seconds_count{reason="say \"hi\""} 1
+`
+	if got := b.String(); got != want {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestSplitLabels(t *testing.T) {
+	for name, want := range map[string][2]string{
+		`svc.a`:                   {`svc.a`, ``},
+		`svc.a{x="1",y="a\"b\n"}`: {`svc.a`, `{x="1",y="a\"b\n"}`},
+		`svc.a{}`:                 {`svc.a`, `{}`},
+		`svc.a{weird}`:            {`svc.a{weird}`, ``},
+		`svc.a{x="1"} 2 {y="2"}`:  {`svc.a{x="1"} 2 {y="2"}`, ``},
+		`svc.a{x="1",}`:           {`svc.a{x="1",}`, ``},
+		`svc.a{1x="1"}`:           {`svc.a{1x="1"}`, ``},
+		`svc.a{x="\q"}`:           {`svc.a{x="\q"}`, ``},
+	} {
+		if base, labels := splitLabels(name); base != want[0] || labels != want[1] {
+			t.Fatalf(`Split %q into %q and %q`, name, base, labels)
+		}
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	r := metrics.NewRegistry()
+	NewCollectors(r, "someawesomeservice").Attempt.Mark(1)
+	rec := httptest.NewRecorder()
+	PrometheusHandler(r).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf(`Unexpected content type %q`, ct)
+	}
+	body, _ := ioutil.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "\nsomeawesomeservice_attempt_total 1\n") {
+		t.Fatalf("Expected the attempt meter in:\n%s", body)
+	}
+}
@@ -15,17 +15,61 @@
 package measured
 
 import (
-	//"context"
+	"context"
+	"errors"
 	"github.com/buildertools/svctools-go/clients"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Collectors receive the measurements of a retry loop. Each call ends in exactly one
+// outcome: SuccessFirstTry, SuccessAfterRetry, NonRetriableFailure, Exhausted or
+// Cancelled. Any collector may be nil, in which case its measurement is dropped.
 type Collectors struct {
-	Attempt     Meter
-	Error       Meter
-	Fatal       Meter
-	TotalTime   Timer
+	// Attempt is marked at the start of every attempt.
+	Attempt Meter
+	// Error is marked for every attempt that fails with a non-retriable error.
+	//
+	// Deprecated: Error keeps its original meaning for existing dashboards. Use
+	// NonRetriableFailure, which is marked once per call.
+	Error Meter
+	// Fatal is marked for every attempt that fails with a retriable error.
+	//
+	// Deprecated: Fatal keeps its original meaning for existing dashboards. Use
+	// RetriableFailure, which is marked on the same occasions.
+	Fatal Meter
+	// TotalTime is the duration of each call, including every attempt and wait.
+	TotalTime Timer
+	// AttemptTime is the duration of each attempt.
 	AttemptTime Timer
+
+	// SuccessFirstTry is marked when a call succeeds on its first attempt.
+	SuccessFirstTry Meter
+	// SuccessAfterRetry is marked when a call succeeds on a later attempt.
+	SuccessAfterRetry Meter
+	// RetriableFailure is marked for every attempt that fails with a retriable error.
+	RetriableFailure Meter
+	// NonRetriableFailure is marked when a call gives up on a non-retriable error.
+	NonRetriableFailure Meter
+	// Exhausted is marked when a call gives up because its waiter expired, ran out of
+	// attempts or ran out of retry budget.
+	Exhausted Meter
+	// Cancelled is marked when a call gives up because its context was done.
+	Cancelled Meter
+	// BackoffTime is the time actually spent waiting after each failed attempt, measured
+	// on the waiter's Clock.
+	BackoffTime Timer
+	// Attempts is the number of attempts made by each call.
+	Attempts Histogram
+
+	// Failures is marked for every failed attempt. Its series are labeled with the "status"
+	// code of the clients.HTTPError behind the failure, or "none", and whether it was
+	// "retriable".
+	Failures MeterVec
+	// Outcomes is marked once per call. Its series are labeled with the "outcome" of the
+	// call: "success", or why it gave up, such as "expired" or "attempts_exhausted".
+	Outcomes MeterVec
 }
 
 type Timer interface {
@@ -40,32 +84,131 @@ type Meter interface {
 	Mark(int64)
 }
 
-func Retry(f clients.RetryFunc, pw clients.PerishableWaiter, c Collectors) (interface{}, error) {
-	t0 := time.Now()
-	defer func() {
-		c.TotalTime.Update(time.Since(t0))
-	}()
-
-	pw.Start()
-	for {
-		c.Attempt.Mark(1)
-		tn := time.Now()
-		result, err := f()
-		c.AttemptTime.Update(time.Since(tn))
-		if err == nil {
-			return result, nil
-		} else if !err.IsRetriable() {
-			c.Error.Mark(1)
-			return result, err.Error()
-		}
-		c.Fatal.Mark(1)
-
-		if e := pw.WaitOrDie(err.Error()); e != nil {
-			return result, err.Error()
-		}
+type Histogram interface {
+	Update(int64)
+}
+
+func Retry(f clients.RetryFunc, pw clients.PerishableWaiter, c Collectors, obs ...clients.Observer) (interface{}, error) {
+	return RetryContext(context.Background(), func(context.Context) (interface{}, clients.ClientError) {
+		return f()
+	}, pw, c, obs...)
+}
+
+// RetryContext is the instrumented equivalent of clients.RetryContext.
+func RetryContext(ctx context.Context, f clients.CancellableFunc, pw clients.PerishableWaiter, c Collectors, obs ...clients.Observer) (interface{}, error) {
+	m := &measurer{c: c, clock: clients.ClockOf(pw)}
+	return clients.RetryContext(ctx, f, pw, append([]clients.Observer{m}, obs...)...)
+}
+
+// measurer is the clients.Observer that feeds a set of Collectors.
+type measurer struct {
+	c       Collectors
+	clock   clients.Clock
+	waiting bool
+	tw      time.Time
+}
+
+func (m *measurer) OnAttemptStart(attempt int) {
+	m.waited()
+	mark(m.c.Attempt)
+}
+func (m *measurer) OnAttemptEnd(attempt int, result interface{}, err clients.ClientError, d time.Duration) {
+	update(m.c.AttemptTime, d)
+	if err == nil {
+		return
+	}
+	m.tw = m.clock.Now()
+	if m.c.Failures != nil {
+		m.c.Failures.With(Labels{
+			"status":    statusOf(err),
+			"retriable": strconv.FormatBool(err.IsRetriable()),
+		}).Mark(1)
+	}
+	if !err.IsRetriable() {
+		mark(m.c.Error)
+	} else {
+		mark(m.c.Fatal)
+		mark(m.c.RetriableFailure)
+	}
+}
+func (m *measurer) OnBackoff(attempt int, delay time.Duration) {
+	// OnBackoff announces the planned delay, so time the wait itself
+	m.waiting = true
+}
+func (m *measurer) OnGiveUp(err clients.RetryError) {
+	m.waited()
+	switch err.Reason {
+	case clients.StopNonRetriable:
+		mark(m.c.NonRetriableFailure)
+	case clients.StopCancelled:
+		mark(m.c.Cancelled)
+	default:
+		mark(m.c.Exhausted)
+	}
+	m.outcome(outcomeName.Replace(err.Reason.String()))
+	m.done(err.Attempts, err.Elapsed)
+}
+func (m *measurer) OnSuccess(attempt int, result interface{}, elapsed time.Duration) {
+	if attempt == 1 {
+		mark(m.c.SuccessFirstTry)
+	} else {
+		mark(m.c.SuccessAfterRetry)
+	}
+	m.outcome("success")
+	m.done(attempt, elapsed)
+}
+
+// waited records the wait since the last failed attempt, if the loop backed off.
+func (m *measurer) waited() {
+	if !m.waiting {
+		return
+	}
+	m.waiting = false
+	update(m.c.BackoffTime, m.clock.Now().Sub(m.tw))
+}
+func (m *measurer) done(attempts int, elapsed time.Duration) {
+	update(m.c.TotalTime, elapsed)
+	if m.c.Attempts != nil {
+		m.c.Attempts.Update(int64(attempts))
+	}
+}
+
+func (m *measurer) outcome(o string) {
+	if m.c.Outcomes != nil {
+		m.c.Outcomes.With(Labels{"outcome": o}).Mark(1)
 	}
 }
 
+var outcomeName = strings.NewReplacer(" ", "_", "-", "_")
+
+// statusOf returns the status code of the HTTP response behind err, or "none".
+func statusOf(err error) string {
+	var he clients.HTTPError
+	if errors.As(err, &he) {
+		return strconv.Itoa(he.StatusCode)
+	}
+	return "none"
+}
+
+// mark marks m once, if it is set.
+func mark(m Meter) {
+	if m != nil {
+		m.Mark(1)
+	}
+}
+
+// update records d in t, if it is set.
+func update(t Timer, d time.Duration) {
+	if t != nil {
+		t.Update(d)
+	}
+}
+
+// RetryN is the instrumented equivalent of clients.RetryN.
+func RetryN(f clients.RetryFunc, attempts uint, pw clients.PerishableWaiter, c Collectors) (interface{}, error) {
+	return Retry(f, &clients.MaxAttempts{N: attempts, Waiter: pw}, c)
+}
+
 func RetryPeriodic(f clients.RetryFunc,
 	timeout time.Duration,
 	base time.Duration,
@@ -105,3 +248,87 @@ func RetryExponential(f clients.RetryFunc,
 		Jf:        clients.Jitter,
 	}, c)
 }
+func RetryPeriodicContext(ctx context.Context,
+	f clients.CancellableFunc,
+	timeout time.Duration,
+	base time.Duration,
+	maxJitter time.Duration,
+	c Collectors) (interface{}, error) {
+	return RetryContext(ctx, f, &clients.JitteredBackoff{
+		TTL:       timeout,
+		Initial:   base,
+		MaxJitter: maxJitter,
+		Bof:       clients.ConstantBackoff,
+		Jf:        clients.Jitter,
+	}, c)
+}
+func RetryLinearContext(ctx context.Context,
+	f clients.CancellableFunc,
+	timeout time.Duration,
+	base time.Duration,
+	maxJitter time.Duration,
+	c Collectors) (interface{}, error) {
+	return RetryContext(ctx, f, &clients.JitteredBackoff{
+		TTL:       timeout,
+		Initial:   base,
+		MaxJitter: maxJitter,
+		Bof:       clients.LinearBackoff,
+		Jf:        clients.Jitter,
+	}, c)
+}
+func RetryExponentialContext(ctx context.Context,
+	f clients.CancellableFunc,
+	timeout time.Duration,
+	base time.Duration,
+	maxJitter time.Duration,
+	c Collectors) (interface{}, error) {
+	return RetryContext(ctx, f, &clients.JitteredBackoff{
+		TTL:       timeout,
+		Initial:   base,
+		MaxJitter: maxJitter,
+		Bof:       clients.ExponentialBackoff,
+		Jf:        clients.Jitter,
+	}, c)
+}
+func RetryPeriodicN(f clients.RetryFunc,
+	attempts uint,
+	timeout time.Duration,
+	base time.Duration,
+	maxJitter time.Duration,
+	c Collectors) (interface{}, error) {
+	return RetryN(f, attempts, &clients.JitteredBackoff{
+		TTL:       timeout,
+		Initial:   base,
+		MaxJitter: maxJitter,
+		Bof:       clients.ConstantBackoff,
+		Jf:        clients.Jitter,
+	}, c)
+}
+func RetryLinearN(f clients.RetryFunc,
+	attempts uint,
+	timeout time.Duration,
+	base time.Duration,
+	maxJitter time.Duration,
+	c Collectors) (interface{}, error) {
+	return RetryN(f, attempts, &clients.JitteredBackoff{
+		TTL:       timeout,
+		Initial:   base,
+		MaxJitter: maxJitter,
+		Bof:       clients.LinearBackoff,
+		Jf:        clients.Jitter,
+	}, c)
+}
+func RetryExponentialN(f clients.RetryFunc,
+	attempts uint,
+	timeout time.Duration,
+	base time.Duration,
+	maxJitter time.Duration,
+	c Collectors) (interface{}, error) {
+	return RetryN(f, attempts, &clients.JitteredBackoff{
+		TTL:       timeout,
+		Initial:   base,
+		MaxJitter: maxJitter,
+		Bof:       clients.ExponentialBackoff,
+		Jf:        clients.Jitter,
+	}, c)
+}
@@ -0,0 +1,218 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package measured
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/buildertools/svctools-go/clients"
+	"github.com/buildertools/svctools-go/clients/clienttest"
+)
+
+type meter struct{ n int64 }
+
+func (m *meter) Mark(n int64) { m.n += n }
+
+type timer struct{ ds []time.Duration }
+
+func (t *timer) Update(d time.Duration) { t.ds = append(t.ds, d) }
+
+type histogram struct{ vs []int64 }
+
+func (h *histogram) Update(v int64) { h.vs = append(h.vs, v) }
+
+type collected struct {
+	attempt, errors, fatals                                  meter
+	firstTry, afterRetry, retriable, nonRetriable, exhausted meter
+	cancelled                                                meter
+	total, attemptTime, backoff                              timer
+	attempts                                                 histogram
+}
+
+func (c *collected) collectors() Collectors {
+	return Collectors{
+		Attempt:             &c.attempt,
+		Error:               &c.errors,
+		Fatal:               &c.fatals,
+		TotalTime:           &c.total,
+		AttemptTime:         &c.attemptTime,
+		SuccessFirstTry:     &c.firstTry,
+		SuccessAfterRetry:   &c.afterRetry,
+		RetriableFailure:    &c.retriable,
+		NonRetriableFailure: &c.nonRetriable,
+		Exhausted:           &c.exhausted,
+		Cancelled:           &c.cancelled,
+		BackoffTime:         &c.backoff,
+		Attempts:            &c.attempts,
+	}
+}
+
+func failing(n int, err clients.ClientError) clients.RetryFunc {
+	calls := 0
+	return func() (interface{}, clients.ClientError) {
+		calls++
+		if calls <= n {
+			return nil, err
+		}
+		return nil, nil
+	}
+}
+
+func TestRetryOutcomes(t *testing.T) {
+	clock := clienttest.NewFakeClock(time.Unix(0, 0))
+	clock.AutoAdvance = true
+	pw := func() clients.PerishableWaiter {
+		return &clients.JitteredBackoff{
+			TTL:     time.Duration(1) * time.Minute,
+			Initial: time.Duration(10) * time.Millisecond,
+			Bof:     clients.ConstantBackoff,
+			Jf:      clients.NoJitter,
+			Clock:   clock,
+		}
+	}
+	retriable := clients.Retriable(errors.New(`retriable`))
+
+	var c collected
+	Retry(failing(0, nil), pw(), c.collectors())
+	Retry(failing(2, retriable), pw(), c.collectors())
+	Retry(failing(1, clients.NonRetriable(errors.New(`fatal`))), pw(), c.collectors())
+	RetryN(failing(5, retriable), 2, pw(), c.collectors())
+
+	if c.firstTry.n != 1 || c.afterRetry.n != 1 || c.nonRetriable.n != 1 || c.exhausted.n != 1 || c.cancelled.n != 0 {
+		t.Fatalf(`Unexpected outcomes %+v`, c)
+	}
+	if c.attempt.n != 7 || c.retriable.n != 4 || c.fatals.n != 4 || c.errors.n != 1 {
+		t.Fatalf(`Unexpected attempt counts %+v`, c)
+	}
+	if want := []int64{1, 3, 1, 2}; !reflect.DeepEqual(c.attempts.vs, want) {
+		t.Fatalf(`Expected attempts %v, got %v`, want, c.attempts.vs)
+	}
+	if len(c.backoff.ds) != 3 {
+		t.Fatalf(`Expected 3 waits, got %v`, c.backoff.ds)
+	}
+	for _, d := range c.backoff.ds {
+		if d != time.Duration(10)*time.Millisecond {
+			t.Fatalf(`Expected every wait to take 10ms, got %v`, c.backoff.ds)
+		}
+	}
+	if len(c.total.ds) != 4 || c.total.ds[1] != time.Duration(20)*time.Millisecond {
+		t.Fatalf(`Unexpected total times %v`, c.total.ds)
+	}
+}
+
+// sleeper is a waiter that is not a clients.Scheduler.
+type sleeper struct{ d time.Duration }
+
+func (s sleeper) Start()        {}
+func (s sleeper) IsDying() bool { return false }
+func (s sleeper) WaitOrDie(e error) error {
+	time.Sleep(s.d)
+	return nil
+}
+
+func TestRetryBackoffPlainWaiter(t *testing.T) {
+	var c collected
+	d := time.Duration(20) * time.Millisecond
+	Retry(failing(1, clients.Retriable(errors.New(`retriable`))), sleeper{d: d}, c.collectors())
+	if len(c.backoff.ds) != 1 || c.backoff.ds[0] < d {
+		t.Fatalf(`Expected a wait of at least %v, got %v`, d, c.backoff.ds)
+	}
+}
+
+func TestRetryBackoffCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var c collected
+	go func() {
+		time.Sleep(time.Duration(20) * time.Millisecond)
+		cancel()
+	}()
+	RetryContext(ctx, func(context.Context) (interface{}, clients.ClientError) {
+		return nil, clients.Retriable(errors.New(`retriable`))
+	}, &clients.JitteredBackoff{
+		TTL:     time.Hour,
+		Initial: time.Minute,
+		Bof:     clients.ConstantBackoff,
+		Jf:      clients.NoJitter,
+	}, c.collectors())
+	if len(c.backoff.ds) != 1 || c.backoff.ds[0] >= time.Minute {
+		t.Fatalf(`Expected the time waited before cancellation, got %v`, c.backoff.ds)
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var c collected
+	RetryContext(ctx, func(context.Context) (interface{}, clients.ClientError) {
+		return nil, nil
+	}, &clients.JitteredBackoff{TTL: time.Minute, Bof: clients.NoBackoff, Jf: clients.NoJitter}, c.collectors())
+	if c.cancelled.n != 1 || c.attempt.n != 0 || len(c.total.ds) != 1 {
+		t.Fatalf(`Unexpected collection %+v`, c)
+	}
+}
+
+func TestRetryOptionalCollectors(t *testing.T) {
+	var c collected
+	_, err := Retry(failing(1, clients.Retriable(errors.New(`retriable`))),
+		&clients.JitteredBackoff{TTL: time.Minute, Bof: clients.NoBackoff, Jf: clients.NoJitter},
+		Collectors{
+			Attempt:     &c.attempt,
+			Error:       &c.errors,
+			Fatal:       &c.fatals,
+			TotalTime:   &c.total,
+			AttemptTime: &c.attemptTime,
+		})
+	if err != nil || c.attempt.n != 2 || c.fatals.n != 1 {
+		t.Fatalf(`Unexpected collection %+v with %v`, c, err)
+	}
+}
+
+type fakeMeterVec map[string]*meter
+
+func (v fakeMeterVec) With(l Labels) Meter {
+	k := encodeLabels(l)
+	if v[k] == nil {
+		v[k] = &meter{}
+	}
+	return v[k]
+}
+
+func TestRetryLabels(t *testing.T) {
+	failures, outcomes := fakeMeterVec{}, fakeMeterVec{}
+	c := Collectors{Failures: failures, Outcomes: outcomes}
+	pw := func() clients.PerishableWaiter {
+		return &clients.JitteredBackoff{TTL: time.Minute, Bof: clients.NoBackoff, Jf: clients.NoJitter}
+	}
+	unavailable := clients.Retriable(clients.HTTPError{StatusCode: 503, Status: `503 Service Unavailable`})
+	RetryN(failing(5, unavailable), 2, pw(), c)
+	Retry(failing(1, clients.NonRetriable(errors.New(`fatal`))), pw(), c)
+	Retry(failing(0, nil), pw(), c)
+
+	if n := failures[`{retriable="true",status="503"}`]; n == nil || n.n != 2 {
+		t.Fatalf(`Expected 2 failures with status 503, got %v`, failures)
+	}
+	if n := failures[`{retriable="false",status="none"}`]; n == nil || n.n != 1 {
+		t.Fatalf(`Expected 1 non-retriable failure without a status, got %v`, failures)
+	}
+	for _, o := range []string{`attempts_exhausted`, `non_retriable`, `success`} {
+		if n := outcomes[`{outcome="`+o+`"}`]; n == nil || n.n != 1 {
+			t.Fatalf(`Expected one %v outcome, got %v`, o, outcomes)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package measured
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Totaler is implemented by metrics that keep a running total of every value they are
+// updated with. WritePrometheus writes that total as the _sum of a summary.
+type Totaler interface {
+	Total() int64
+}
+
+// NewSummedTimer returns a go-metrics timer that also keeps the running total of every
+// duration it records, in nanoseconds. NewCollectors and NewTimerVec use it.
+func NewSummedTimer() metrics.Timer {
+	return &summedTimer{Timer: metrics.NewTimer()}
+}
+
+// NewSummedHistogram returns a go-metrics histogram over s that also keeps the running
+// total of every value it records. Clear resets the total. NewCollectors uses it.
+func NewSummedHistogram(s metrics.Sample) metrics.Histogram {
+	return &summedHistogram{Histogram: metrics.NewHistogram(s)}
+}
+
+type summedTimer struct {
+	total int64
+	metrics.Timer
+}
+
+func (t *summedTimer) Total() int64 {
+	return atomic.LoadInt64(&t.total)
+}
+func (t *summedTimer) Time(f func()) {
+	ts := time.Now()
+	f()
+	t.Update(time.Since(ts))
+}
+func (t *summedTimer) Update(d time.Duration) {
+	t.Timer.Update(d)
+	atomic.AddInt64(&t.total, int64(d))
+}
+func (t *summedTimer) UpdateSince(ts time.Time) {
+	t.Update(time.Since(ts))
+}
+
+type summedHistogram struct {
+	total int64
+	metrics.Histogram
+}
+
+func (h *summedHistogram) Total() int64 {
+	return atomic.LoadInt64(&h.total)
+}
+func (h *summedHistogram) Clear() {
+	h.Histogram.Clear()
+	atomic.StoreInt64(&h.total, 0)
+}
+func (h *summedHistogram) Update(v int64) {
+	h.Histogram.Update(v)
+	atomic.AddInt64(&h.total, v)
+}
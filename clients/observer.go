@@ -0,0 +1,76 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"time"
+)
+
+// Observer receives the events of a retry loop. Attempts are numbered from one. Every
+// attempt is bracketed by OnAttemptStart and OnAttemptEnd, a failed attempt that will be
+// retried is followed by OnBackoff, and the loop ends with either OnSuccess or OnGiveUp.
+//
+// OnBackoff announces each wait before it begins with the delay planned for it. A delay
+// that would outlast the waiter's deadline is cut short at the deadline, and the wait is
+// then followed by OnGiveUp, as is a wait abandoned because the context is done. Waiters
+// outside this package do not plan their delay, so for them OnBackoff reports how long
+// the wait took once it is over, timed on their Clock.
+//
+// Observers are called synchronously from the retry loop and should return quickly.
+type Observer interface {
+	OnAttemptStart(attempt int)
+	OnAttemptEnd(attempt int, result interface{}, err ClientError, d time.Duration)
+	OnBackoff(attempt int, delay time.Duration)
+	OnGiveUp(err RetryError)
+	OnSuccess(attempt int, result interface{}, elapsed time.Duration)
+}
+
+// NopObserver ignores every event. Embed it to implement only the events of interest.
+type NopObserver struct{}
+
+func (NopObserver) OnAttemptStart(attempt int)                                                     {}
+func (NopObserver) OnAttemptEnd(attempt int, result interface{}, err ClientError, d time.Duration) {}
+func (NopObserver) OnBackoff(attempt int, delay time.Duration)                                     {}
+func (NopObserver) OnGiveUp(err RetryError)                                                        {}
+func (NopObserver) OnSuccess(attempt int, result interface{}, elapsed time.Duration)               {}
+
+// observers fans events out to every Observer in order.
+type observers []Observer
+
+func (os observers) OnAttemptStart(attempt int) {
+	for _, o := range os {
+		o.OnAttemptStart(attempt)
+	}
+}
+func (os observers) OnAttemptEnd(attempt int, result interface{}, err ClientError, d time.Duration) {
+	for _, o := range os {
+		o.OnAttemptEnd(attempt, result, err, d)
+	}
+}
+func (os observers) OnBackoff(attempt int, delay time.Duration) {
+	for _, o := range os {
+		o.OnBackoff(attempt, delay)
+	}
+}
+func (os observers) OnGiveUp(err RetryError) {
+	for _, o := range os {
+		o.OnGiveUp(err)
+	}
+}
+func (os observers) OnSuccess(attempt int, result interface{}, elapsed time.Duration) {
+	for _, o := range os {
+		o.OnSuccess(attempt, result, elapsed)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	NopObserver
+	events []string
+	giveUp *RetryError
+}
+
+func (r *recordingObserver) OnAttemptStart(attempt int) {
+	r.events = append(r.events, fmt.Sprintf(`start %d`, attempt))
+}
+func (r *recordingObserver) OnAttemptEnd(attempt int, result interface{}, err ClientError, d time.Duration) {
+	r.events = append(r.events, fmt.Sprintf(`end %d %v %v`, attempt, result, err))
+}
+func (r *recordingObserver) OnBackoff(attempt int, delay time.Duration) {
+	r.events = append(r.events, fmt.Sprintf(`backoff %d %v`, attempt, delay))
+}
+func (r *recordingObserver) OnGiveUp(err RetryError) {
+	r.events = append(r.events, fmt.Sprintf(`give up %v`, err.Reason))
+	r.giveUp = &err
+}
+func (r *recordingObserver) OnSuccess(attempt int, result interface{}, elapsed time.Duration) {
+	r.events = append(r.events, fmt.Sprintf(`success %d %v`, attempt, result))
+}
+
+func TestRetryObserverSuccess(t *testing.T) {
+	o1, o2 := &recordingObserver{}, &recordingObserver{}
+	calls := 0
+	Retry(func() (interface{}, ClientError) {
+		calls++
+		if calls < 3 {
+			return calls, Retriable(errors.New(`e1`))
+		}
+		return `ok`, nil
+	}, &JitteredBackoff{
+		TTL:     time.Duration(1) * time.Minute,
+		Initial: time.Duration(1) * time.Microsecond,
+		Bof:     LinearBackoff,
+		Jf:      NoJitter,
+	}, o1, o2)
+	want := []string{
+		`start 1`, `end 1 1 e1`, `backoff 1 1µs`,
+		`start 2`, `end 2 2 e1`, `backoff 2 2µs`,
+		`start 3`, `end 3 ok <nil>`, `success 3 ok`,
+	}
+	if !reflect.DeepEqual(o1.events, want) {
+		t.Fatalf(`Expected %q, got %q`, want, o1.events)
+	}
+	if !reflect.DeepEqual(o2.events, want) {
+		t.Fatal(`The second observer saw different events`)
+	}
+}
+
+func TestRetryObserverGiveUp(t *testing.T) {
+	o := &recordingObserver{}
+	_, err := Retry(func() (interface{}, ClientError) {
+		return nil, Retriable(errors.New(`e1`))
+	}, &MaxAttempts{N: 2, Waiter: &JitteredBackoff{TTL: time.Duration(1) * time.Minute, Bof: NoBackoff, Jf: NoJitter}}, o)
+	want := []string{
+		`start 1`, `end 1 <nil> e1`, `backoff 1 0s`,
+		`start 2`, `end 2 <nil> e1`, `give up attempts exhausted`,
+	}
+	if !reflect.DeepEqual(o.events, want) {
+		t.Fatalf(`Expected %q, got %q`, want, o.events)
+	}
+	if o.giveUp == nil || !reflect.DeepEqual(*o.giveUp, err) {
+		t.Fatalf(`Expected OnGiveUp to see %v, got %v`, err, o.giveUp)
+	}
+
+	o = &recordingObserver{}
+	Retry(func() (interface{}, ClientError) {
+		return nil, NonRetriable(errors.New(`e2`))
+	}, &JitteredBackoff{TTL: time.Duration(1) * time.Minute, Bof: NoBackoff, Jf: NoJitter}, o)
+	want = []string{`start 1`, `end 1 <nil> e2`, `give up non-retriable`}
+	if !reflect.DeepEqual(o.events, want) {
+		t.Fatalf(`Expected %q, got %q`, want, o.events)
+	}
+}
+
+func TestRetryObserverTruncatedWait(t *testing.T) {
+	var delays []time.Duration
+	o := &backoffObserver{delays: &delays}
+	Retry(func() (interface{}, ClientError) {
+		return nil, Retriable(errors.New(`e1`))
+	}, &JitteredBackoff{
+		TTL:     time.Duration(1) * time.Second,
+		Initial: time.Duration(10) * time.Millisecond,
+		Bof:     ExponentialBackoff,
+		Jf:      NoJitter,
+		Clock:   &virtualClock{now: time.Unix(0, 0)},
+	}, o)
+	var want []time.Duration
+	for _, ms := range []int{10, 20, 40, 80, 160, 320, 370} {
+		want = append(want, time.Duration(ms)*time.Millisecond)
+	}
+	if !reflect.DeepEqual(delays, want) {
+		t.Fatalf(`Expected the waits actually slept %v, got %v`, want, delays)
+	}
+}
+
+// clockObserver records the time on a clock at the end of each attempt and at each
+// backoff.
+type clockObserver struct {
+	NopObserver
+	c            *virtualClock
+	ended, began []time.Time
+}
+
+func (o *clockObserver) OnAttemptEnd(attempt int, result interface{}, err ClientError, d time.Duration) {
+	o.ended = append(o.ended, o.c.now)
+}
+func (o *clockObserver) OnBackoff(attempt int, delay time.Duration) {
+	o.began = append(o.began, o.c.now)
+}
+
+func TestRetryObserverBackoffBeforeWait(t *testing.T) {
+	c := &virtualClock{now: time.Unix(0, 0)}
+	o := &clockObserver{c: c}
+	Retry(func() (interface{}, ClientError) {
+		return nil, Retriable(errors.New(`e1`))
+	}, &MaxAttempts{N: 3, Waiter: &JitteredBackoff{
+		TTL:     time.Duration(1) * time.Minute,
+		Initial: time.Duration(1) * time.Second,
+		Bof:     ConstantBackoff,
+		Jf:      NoJitter,
+		Clock:   c,
+	}}, o)
+	if len(o.began) != 2 {
+		t.Fatalf(`Expected 2 backoffs, got %v`, len(o.began))
+	}
+	for i, b := range o.began {
+		if !b.Equal(o.ended[i]) {
+			t.Fatalf(`Backoff %v was reported after the wait`, i+1)
+		}
+	}
+
+	// a wait abandoned on cancellation is still announced
+	ctx, cancel := context.WithCancel(context.Background())
+	var delays []time.Duration
+	RetryContext(ctx, func(context.Context) (interface{}, ClientError) {
+		return nil, Retriable(errors.New(`e1`))
+	}, &JitteredBackoff{
+		TTL:     time.Duration(1) * time.Hour,
+		Initial: time.Duration(1) * time.Minute,
+		Bof:     ConstantBackoff,
+		Jf:      NoJitter,
+	}, &backoffObserver{delays: &delays}, cancelObserver{cancel})
+	if len(delays) != 1 || delays[0] != time.Duration(1)*time.Minute {
+		t.Fatalf(`Expected the abandoned wait to be announced, got %v`, delays)
+	}
+}
+
+// cancelObserver cancels the retry loop as soon as it backs off.
+type cancelObserver struct {
+	cancel func()
+}
+
+func (c cancelObserver) OnAttemptStart(int)                                        {}
+func (c cancelObserver) OnAttemptEnd(int, interface{}, ClientError, time.Duration) {}
+func (c cancelObserver) OnBackoff(int, time.Duration)                              { c.cancel() }
+func (c cancelObserver) OnGiveUp(RetryError)                                       {}
+func (c cancelObserver) OnSuccess(int, interface{}, time.Duration)                 {}
+
+type countingWaiter struct {
+	waits int
+}
+
+func (c *countingWaiter) Start()        {}
+func (c *countingWaiter) IsDying() bool { return true }
+func (c *countingWaiter) WaitOrDie(e error) error {
+	c.waits++
+	if c.waits > 1 {
+		return e
+	}
+	time.Sleep(time.Duration(1) * time.Millisecond)
+	return nil
+}
+
+func TestRetryObserverPlainWaiter(t *testing.T) {
+	var delays []time.Duration
+	o := &backoffObserver{delays: &delays}
+	Retry(func() (interface{}, ClientError) {
+		return nil, Retriable(errors.New(`e1`))
+	}, &countingWaiter{}, o)
+	if len(delays) != 1 || delays[0] < time.Duration(1)*time.Millisecond {
+		t.Fatalf(`Expected one measured backoff of at least 1ms, got %v`, delays)
+	}
+}
+
+// clockedWaiter is a waiter from outside the package that waits on its own Clock.
+type clockedWaiter struct {
+	countingWaiter
+	c *virtualClock
+}
+
+func (c *clockedWaiter) WaitOrDie(e error) error {
+	if err := c.countingWaiter.WaitOrDie(e); err != nil {
+		return err
+	}
+	c.c.now = c.c.now.Add(time.Duration(5) * time.Second)
+	return nil
+}
+func (c *clockedWaiter) WaitClock() Clock { return c.c }
+
+func TestRetryClockedWaiter(t *testing.T) {
+	var delays []time.Duration
+	o := &backoffObserver{delays: &delays}
+	_, err := Retry(func() (interface{}, ClientError) {
+		return nil, Retriable(errors.New(`e1`))
+	}, &clockedWaiter{c: &virtualClock{now: time.Unix(0, 0)}}, o)
+	d := time.Duration(5) * time.Second
+	if len(delays) != 1 || delays[0] < d {
+		t.Fatalf(`Expected the wait to be measured on the waiter's clock, got %v`, delays)
+	}
+	var re RetryError
+	if !errors.As(err, &re) || re.Elapsed < d {
+		t.Fatalf(`Expected the elapsed time to be measured on the waiter's clock, got %v`, err)
+	}
+}
+
+type backoffObserver struct {
+	NopObserver
+	delays *[]time.Duration
+}
+
+func (b *backoffObserver) OnBackoff(attempt int, delay time.Duration) {
+	*b.delays = append(*b.delays, delay)
+}
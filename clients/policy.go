@@ -0,0 +1,85 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"context"
+	"time"
+)
+
+// Policy describes how to retry a call. Unlike a JitteredBackoff it holds no per-call state:
+// every call gets a fresh waiter from NewWaiter, so a single Policy can live in a package
+// level variable and be used from any number of goroutines.
+//
+//	var fetchPolicy = clients.Policy{
+//		TTL:       time.Duration(30) * time.Second,
+//		Initial:   time.Duration(50) * time.Millisecond,
+//		MaxJitter: time.Duration(50) * time.Millisecond,
+//	}
+//
+//	r, err := fetchPolicy.RetryContext(ctx, fetch)
+//
+// The fields mean the same as on JitteredBackoff. Bof defaults to ExponentialBackoff and,
+// when neither Jf nor Strategy is set, Jf defaults to Jitter.
+type Policy struct {
+	TTL       time.Duration
+	Initial   time.Duration
+	MaxJitter time.Duration
+	MaxDelay  time.Duration
+	// MaxAttempts limits the number of attempts made. Zero means attempts are only limited
+	// by the TTL.
+	MaxAttempts    uint
+	AttemptTimeout time.Duration
+	Bof            BackoffFunc
+	Jf             JitterFunc
+	Strategy       DelayStrategy
+	Clock          Clock
+}
+
+// NewWaiter returns a new waiter that follows the policy. It has the signature of
+// Transport.NewWaiter.
+func (p Policy) NewWaiter() PerishableWaiter {
+	w := &JitteredBackoff{
+		TTL:            p.TTL,
+		Initial:        p.Initial,
+		MaxJitter:      p.MaxJitter,
+		MaxDelay:       p.MaxDelay,
+		Bof:            p.Bof,
+		Jf:             p.Jf,
+		Strategy:       p.Strategy,
+		AttemptTimeout: p.AttemptTimeout,
+		Clock:          p.Clock,
+	}
+	if w.Bof == nil {
+		w.Bof = ExponentialBackoff
+	}
+	if w.Jf == nil && w.Strategy == nil {
+		w.Jf = Jitter
+	}
+	if p.MaxAttempts > 0 {
+		return &MaxAttempts{N: p.MaxAttempts, Waiter: w}
+	}
+	return w
+}
+
+// Retry calls Retry with a new waiter.
+func (p Policy) Retry(f RetryFunc) (interface{}, error) {
+	return Retry(f, p.NewWaiter())
+}
+
+// RetryContext calls RetryContext with a new waiter.
+func (p Policy) RetryContext(ctx context.Context, f CancellableFunc) (interface{}, error) {
+	return RetryContext(ctx, f, p.NewWaiter())
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPolicyNewWaiter(t *testing.T) {
+	p := Policy{TTL: time.Duration(1) * time.Minute, Initial: time.Duration(10), MaxDelay: time.Duration(30)}
+	w, ok := p.NewWaiter().(*JitteredBackoff)
+	if !ok {
+		t.Fatal(`Expected a JitteredBackoff without MaxAttempts`)
+	}
+	if w.Bof == nil || w.Jf == nil || w.MaxDelay != p.MaxDelay || w.TTL != p.TTL {
+		t.Fatalf(`Unexpected waiter %+v`, w)
+	}
+	if p.NewWaiter() == PerishableWaiter(w) {
+		t.Fatal(`NewWaiter returned the same waiter twice`)
+	}
+
+	p.Strategy = FullJitter
+	if w := p.NewWaiter().(*JitteredBackoff); w.Jf != nil {
+		t.Fatal(`Jf should stay nil when a Strategy is set`)
+	}
+
+	p.MaxAttempts = 2
+	if _, ok := p.NewWaiter().(*MaxAttempts); !ok {
+		t.Fatal(`Expected MaxAttempts to wrap the waiter`)
+	}
+}
+
+func TestPolicyRetry(t *testing.T) {
+	p := Policy{TTL: time.Duration(1) * time.Minute, MaxAttempts: 3, Bof: NoBackoff, Jf: NoJitter}
+	calls := 0
+	_, err := p.Retry(func() (interface{}, ClientError) {
+		calls++
+		return nil, Retriable(errors.New(`e1`))
+	})
+	var re RetryError
+	if !errors.As(err, &re) || re.Reason != StopExhausted {
+		t.Fatalf(`Expected an exhausted RetryError, got %v`, err)
+	}
+	if calls != 3 {
+		t.Fatalf(`Expected 3 calls, got %v`, calls)
+	}
+
+	// the policy carries no state from one call to the next
+	calls = 0
+	p.Retry(func() (interface{}, ClientError) {
+		calls++
+		return nil, Retriable(errors.New(`e1`))
+	})
+	if calls != 3 {
+		t.Fatalf(`Expected 3 calls on reuse, got %v`, calls)
+	}
+}
+
+func TestPolicyConcurrent(t *testing.T) {
+	p := Policy{TTL: time.Duration(1) * time.Minute, Initial: time.Duration(1), MaxJitter: time.Duration(1), MaxAttempts: 5}
+	var calls int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Retry(func() (interface{}, ClientError) {
+				atomic.AddInt64(&calls, 1)
+				return nil, Retriable(errors.New(`e1`))
+			})
+		}()
+	}
+	wg.Wait()
+	if calls != 40 {
+		t.Fatalf(`Expected 40 calls, got %v`, calls)
+	}
+}
@@ -23,19 +23,74 @@ import (
 type RetryFunc func() (interface{}, ClientError)
 type CancellableFunc func(ctx context.Context) (interface{}, ClientError)
 
-func Retry(f RetryFunc, pw PerishableWaiter) (interface{}, error) {
+// Retry calls f until it succeeds, fails with an error that is not retriable, or pw gives
+// up. On failure it returns a RetryError describing every attempt, which unwraps to the
+// cause of the last attempt's error, along with the result of the last attempt. Results
+// of earlier attempts are discarded once a later attempt supersedes them: response bodies
+// are drained and closed and Discarders are discarded. A failed response body of up to
+// 64KB is read into memory and closed before each wait, so its connection is not held
+// while waiting; the response still reads in full if it is the one returned. Every
+// Observer in obs is told about each event of the loop.
+func Retry(f RetryFunc, pw PerishableWaiter, obs ...Observer) (interface{}, error) {
+	return RetryContext(context.Background(), func(context.Context) (interface{}, ClientError) {
+		return f()
+	}, pw, obs...)
+}
+
+// RetryContext is like Retry but passes ctx into each attempt and gives up as soon as
+// ctx is done. When that happens the returned RetryError unwraps to a CancelledError. A
+// waiter that does not implement ContextWaiter is left waiting in a goroutine when ctx is
+// done, as described there.
+func RetryContext(ctx context.Context, f CancellableFunc, pw PerishableWaiter, obs ...Observer) (interface{}, error) {
+	clock := ClockOf(pw)
+	t0 := clock.Now()
+	o := observers(obs)
+	var errs []error
+	giveUp := func(reason StopReason, cause error) error {
+		re := RetryError{
+			Attempts: len(errs),
+			Elapsed:  clock.Now().Sub(t0),
+			Reason:   reason,
+			Errors:   errs,
+			Err:      cause,
+		}
+		o.OnGiveUp(re)
+		return re
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, giveUp(StopCancelled, CancelledError{Err: err})
+	}
 	pw.Start()
 	for {
-		result, err := f()
+		n := len(errs) + 1
+		o.OnAttemptStart(n)
+		tn := clock.Now()
+		result, err := attempt(ctx, f, pw)
+		o.OnAttemptEnd(n, result, err, clock.Now().Sub(tn))
 		if err == nil {
+			o.OnSuccess(n, result, clock.Now().Sub(t0))
 			return result, nil
+		}
+		errs = append(errs, err)
+		if ce := ctx.Err(); ce != nil {
+			return result, giveUp(StopCancelled, CancelledError{Err: ce, Last: causeOf(err)})
 		} else if !err.IsRetriable() {
-			return result, err.Error()
+			return result, giveUp(StopNonRetriable, causeOf(err))
 		}
 
-		if e := pw.WaitOrDie(err.Error()); e != nil {
-			return result, err.Error()
+		settle(result)
+		var planned func(time.Duration)
+		if len(o) > 0 {
+			planned = func(d time.Duration) { o.OnBackoff(n, d) }
+		}
+		if e := waitOrDie(ctx, pw, err, planned); e != nil {
+			if ce := ctx.Err(); ce != nil {
+				return result, giveUp(StopCancelled, CancelledError{Err: ce, Last: causeOf(err)})
+			}
+			return result, giveUp(stopReason(pw), causeOf(err))
 		}
+		discard(result)
 	}
 }
 
@@ -69,9 +124,109 @@ func RetryExponential(f RetryFunc, timeout time.Duration, initial time.Duration,
 	})
 }
 
+func RetryPeriodicContext(ctx context.Context, f CancellableFunc, timeout time.Duration, interval time.Duration, maxJitter time.Duration) (interface{}, error) {
+	return RetryContext(ctx, f, &JitteredBackoff{
+		TTL:       timeout,
+		Initial:   interval,
+		MaxJitter: maxJitter,
+		Bof:       ConstantBackoff,
+		Jf:        Jitter,
+	})
+}
+
+func RetryLinearContext(ctx context.Context, f CancellableFunc, timeout time.Duration, interval time.Duration, maxJitter time.Duration) (interface{}, error) {
+	return RetryContext(ctx, f, &JitteredBackoff{
+		TTL:       timeout,
+		Initial:   interval,
+		MaxJitter: maxJitter,
+		Bof:       LinearBackoff,
+		Jf:        Jitter,
+	})
+}
+
+func RetryExponentialContext(ctx context.Context, f CancellableFunc, timeout time.Duration, initial time.Duration, maxJitter time.Duration) (interface{}, error) {
+	return RetryContext(ctx, f, &JitteredBackoff{
+		TTL:       timeout,
+		Initial:   initial,
+		MaxJitter: maxJitter,
+		Bof:       ExponentialBackoff,
+		Jf:        Jitter,
+	})
+}
+
+// RetryN is like Retry but makes at most attempts attempts, giving up on whichever of the
+// attempt limit and pw is reached first.
+func RetryN(f RetryFunc, attempts uint, pw PerishableWaiter) (interface{}, error) {
+	return Retry(f, &MaxAttempts{N: attempts, Waiter: pw})
+}
+
+func RetryPeriodicN(f RetryFunc, attempts uint, timeout time.Duration, interval time.Duration, maxJitter time.Duration) (interface{}, error) {
+	return RetryN(f, attempts, &JitteredBackoff{
+		TTL:       timeout,
+		Initial:   interval,
+		MaxJitter: maxJitter,
+		Bof:       ConstantBackoff,
+		Jf:        Jitter,
+	})
+}
+
+func RetryLinearN(f RetryFunc, attempts uint, timeout time.Duration, interval time.Duration, maxJitter time.Duration) (interface{}, error) {
+	return RetryN(f, attempts, &JitteredBackoff{
+		TTL:       timeout,
+		Initial:   interval,
+		MaxJitter: maxJitter,
+		Bof:       LinearBackoff,
+		Jf:        Jitter,
+	})
+}
+
+func RetryExponentialN(f RetryFunc, attempts uint, timeout time.Duration, initial time.Duration, maxJitter time.Duration) (interface{}, error) {
+	return RetryN(f, attempts, &JitteredBackoff{
+		TTL:       timeout,
+		Initial:   initial,
+		MaxJitter: maxJitter,
+		Bof:       ExponentialBackoff,
+		Jf:        Jitter,
+	})
+}
+
 type Waiter interface {
 	WaitOrDie(e error) error
 }
+
+// AttemptDeadliner is implemented by waiters that bound the duration of each attempt.
+// RetryContext calls AttemptDeadline before every attempt and, when ok is true, runs the
+// attempt with a child context that expires at the returned time. The deadline is read on
+// the waiter's Clock but the context runs in real time: it expires once as much real time
+// has passed as was left until the deadline when the attempt began.
+type AttemptDeadliner interface {
+	AttemptDeadline() (deadline time.Time, ok bool)
+}
+
+// Scheduler is implemented by waiters that can plan the wait before the next attempt
+// without sleeping. Next returns that delay, or a non-nil error if no further attempt
+// should be made. Deadline reports when the waiter expires, if it ever does; a wait that
+// would outlast the deadline ends at the deadline and gives up.
+//
+// The waiter combinators in this package use Scheduler to merge the plans of their
+// components into a single wait.
+type Scheduler interface {
+	PerishableWaiter
+	Next(e error) (time.Duration, error)
+	Deadline() (time.Time, bool)
+}
+
+// ContextWaiter is implemented by waiters that can abandon a wait when a context is done.
+// WaitOrDieContext returns ctx.Err() in that case.
+//
+// Every waiter in this package implements it. RetryContext can only abandon the wait of a
+// waiter that does not by returning while WaitOrDie is still running in another goroutine.
+// That goroutine lives until WaitOrDie returns, and anything it does then races with later
+// use of the waiter, so a waiter that does not implement ContextWaiter must not be reused
+// after a cancelled call and should not wait for long.
+type ContextWaiter interface {
+	WaitOrDieContext(ctx context.Context, e error) error
+}
 type Perishable interface {
 	Start()
 	IsDying() bool
@@ -82,20 +237,44 @@ type PerishableWaiter interface {
 }
 
 type JitteredBackoff struct {
-	dead      <-chan time.Time
+	started   bool
+	deadline  time.Time
 	round     uint
+	previous  time.Duration
 	TTL       time.Duration
 	Initial   time.Duration
 	MaxJitter time.Duration
-	Bof       BackoffFunc
-	Jf        JitterFunc
+	// MaxDelay caps the output of Bof before any jitter is applied. Zero means no cap.
+	MaxDelay time.Duration
+	Bof      BackoffFunc
+	Jf       JitterFunc
+	// Strategy, when set, chooses each delay from the output of Bof in place of adding
+	// Jf(MaxJitter) to it, and Jf may be nil.
+	Strategy DelayStrategy
+	// AttemptTimeout bounds each individual attempt made with RetryContext. Zero means
+	// attempts are only bounded by the context. The attempt deadline never extends past
+	// the TTL. The body of an *http.Response returned by an attempt stays readable until
+	// it is closed or the attempt deadline passes. Attempts are timed in real time even
+	// with another Clock, as described for AttemptDeadliner.
+	AttemptTimeout time.Duration
+	// Clock defaults to SystemClock.
+	Clock Clock
 }
 
 func (w *JitteredBackoff) WaitOrDie(e error) error {
+	return w.WaitOrDieContext(context.Background(), e)
+}
+func (w *JitteredBackoff) WaitOrDieContext(ctx context.Context, e error) error {
+	return w.waitPlanned(ctx, e, nil)
+}
+func (w *JitteredBackoff) waitPlanned(ctx context.Context, e error, planned func(time.Duration)) error {
+	return waitScheduled(ctx, w, e, planned)
+}
+func (w *JitteredBackoff) Next(e error) (time.Duration, error) {
 	if w.Bof == nil {
 		panic(errors.New(`Bof is nil`))
 	}
-	if w.Jf == nil {
+	if w.Jf == nil && w.Strategy == nil {
 		panic(errors.New(`Jf is nil`))
 	}
 	var ej, ei time.Duration
@@ -108,17 +287,193 @@ func (w *JitteredBackoff) WaitOrDie(e error) error {
 		ei = w.Initial
 	}
 
-	select {
-	case <-w.dead:
-		return e
-	case <-time.After(w.Bof(w.round, ei) + w.Jf(ej)):
+	base := w.Bof(w.round, ei)
+	if w.MaxDelay > 0 && base > w.MaxDelay {
+		base = w.MaxDelay
+	}
+
+	strategy := w.Strategy
+	if strategy == nil {
+		strategy = AdditiveJitter(w.Jf)
 	}
+	d := strategy(BackoffState{
+		Round:     w.round,
+		Initial:   ei,
+		Base:      base,
+		Previous:  w.previous,
+		MaxJitter: ej,
+		MaxDelay:  w.MaxDelay,
+	})
 	w.round++
-	return nil
+	// wait at least as long as the server asked, unless that outlives the TTL
+	if hint, ok := DelayHint(e); ok && hint > d {
+		if w.started && w.WaitClock().Now().Add(hint).After(w.deadline) {
+			return 0, e
+		}
+		d = hint
+	}
+	w.previous = d
+	return d, nil
+}
+func (w *JitteredBackoff) Deadline() (time.Time, bool) {
+	return w.deadline, w.started
 }
 func (w *JitteredBackoff) Start() {
-	w.dead = time.After(w.TTL)
+	w.round = 0
+	w.previous = 0
+	w.deadline = w.WaitClock().Now().Add(w.TTL)
+	w.started = true
+}
+func (w *JitteredBackoff) AttemptDeadline() (time.Time, bool) {
+	if w.AttemptTimeout <= 0 {
+		return time.Time{}, false
+	}
+	d := w.WaitClock().Now().Add(w.AttemptTimeout)
+	if w.started && w.deadline.Before(d) {
+		d = w.deadline
+	}
+	return d, true
 }
 func (w *JitteredBackoff) IsDying() bool {
-	return w.dead != nil
+	return w.started
+}
+func (w *JitteredBackoff) StopReason() StopReason {
+	return StopExpired
+}
+func (w *JitteredBackoff) WaitClock() Clock {
+	if w.Clock == nil {
+		return SystemClock
+	}
+	return w.Clock
+}
+
+// attempt calls f, bounding it by the attempt deadline of pw if there is one. An attempt
+// that fails because its own deadline passed is always treated as retriable.
+func attempt(ctx context.Context, f CancellableFunc, pw PerishableWaiter) (interface{}, ClientError) {
+	ad, ok := pw.(AttemptDeadliner)
+	if !ok {
+		return f(ctx)
+	}
+	d, ok := ad.AttemptDeadline()
+	if !ok {
+		return f(ctx)
+	}
+	// the deadline comes from the waiter's clock, so convert it to a real time timeout
+	actx, cancel := context.WithTimeout(ctx, d.Sub(ClockOf(pw).Now()))
+	result, err := f(actx)
+	// a response body is still read through the attempt's context
+	if !releaseOnClose(result, cancel) {
+		defer cancel()
+	}
+	if err != nil && ctx.Err() == nil && actx.Err() == context.DeadlineExceeded {
+		if err.Cause() == nil {
+			return result, RetriableError{E: context.DeadlineExceeded}
+		}
+		return result, RetriableError{E: err.Cause()}
+	}
+	return result, err
+}
+
+// stopReason returns the reason pw gave up.
+func stopReason(pw PerishableWaiter) StopReason {
+	if sr, ok := pw.(StopReasoner); ok {
+		return sr.StopReason()
+	}
+	return StopExpired
+}
+
+// planner is implemented by the waiters in this package. waitPlanned waits like
+// WaitOrDieContext and, before it waits, calls planned with how long it is about to wait.
+// planned may be nil.
+type planner interface {
+	waitPlanned(ctx context.Context, e error, planned func(time.Duration)) error
+}
+
+// waitOrDie waits on pw, abandoning the wait if ctx is done first, and reports the wait to
+// planned if it is not nil. A waiter from outside this package does not say how long it
+// will wait, so its wait is reported once it is over, measured on its clock.
+func waitOrDie(ctx context.Context, pw PerishableWaiter, e error, planned func(time.Duration)) error {
+	if r, ok := pw.(planner); ok {
+		return r.waitPlanned(ctx, e, planned)
+	}
+	clock := ClockOf(pw)
+	t0 := clock.Now()
+	err := waitOpaque(ctx, pw, e)
+	if err == nil {
+		report(planned, clock.Now().Sub(t0))
+	}
+	return err
+}
+
+// waitOpaque waits on pw, abandoning the wait if ctx is done first. A waiter that does not
+// implement ContextWaiter is left running WaitOrDie in a goroutine that outlives the call.
+func waitOpaque(ctx context.Context, pw PerishableWaiter, e error) error {
+	if cw, ok := pw.(ContextWaiter); ok {
+		return cw.WaitOrDieContext(ctx, e)
+	}
+	if ctx.Done() == nil {
+		return pw.WaitOrDie(e)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- pw.WaitOrDie(e)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// report calls planned with d if it is not nil.
+func report(planned func(time.Duration), d time.Duration) {
+	if planned != nil {
+		planned(d)
+	}
+}
+
+// waitScheduled implements waitPlanned for a Scheduler.
+func waitScheduled(ctx context.Context, s Scheduler, e error, planned func(time.Duration)) error {
+	d, err := s.Next(e)
+	if err != nil {
+		return err
+	}
+	deadline, ok := s.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+	return sleep(ctx, ClockOf(s), d, deadline, e, planned)
+}
+
+// sleep waits for d on clock, reporting the wait to planned before it begins. A wait
+// that would outlast deadline, if it is set, is cut short at the deadline and returns e.
+// It returns ctx.Err() if ctx is done first.
+func sleep(ctx context.Context, clock Clock, d time.Duration, deadline time.Time, e error, planned func(time.Duration)) error {
+	dies := false
+	if !deadline.IsZero() {
+		remaining := deadline.Sub(clock.Now())
+		if remaining <= 0 {
+			return e
+		}
+		if remaining < d {
+			d, dies = remaining, true
+		}
+	}
+	report(planned, d)
+	if d > 0 {
+		t := clock.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C():
+		}
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+	if dies {
+		return e
+	}
+	return nil
 }
@@ -14,6 +14,7 @@
 package clients
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -63,9 +64,22 @@ func TestRetry(t *testing.T) {
 	if e == nil {
 		t.Fatal(`No error was returned`)
 	}
-	if e != wrappedRe {
+	if !errors.Is(e, wrappedRe) {
 		t.Fatal(`Failed to return the original wrapped error`)
 	}
+	var re RetryError
+	if !errors.As(e, &re) {
+		t.Fatal(`Failed to return a RetryError`)
+	}
+	if re.Attempts != 3 || len(re.Errors) != 3 {
+		t.Fatalf(`Expected 3 attempts, got %v with %v errors`, re.Attempts, len(re.Errors))
+	}
+	if re.Reason != StopNonRetriable {
+		t.Fatalf(`Expected to stop on a non-retriable error, got %v`, re.Reason)
+	}
+	if re.Err != wrappedRe {
+		t.Fatal(`RetryError did not carry the final cause`)
+	}
 }
 
 // The difficulty in exhaustive testing of WaitOrDie is that functionality depends on timing.
@@ -165,3 +179,255 @@ func TestJitteredBackoffStartIsDying(t *testing.T) {
 		t.Fatal(`Should be dying by now`)
 	}
 }
+
+func TestRetryContextCancelledDuringWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lastErr := errors.New(`retriable`)
+	attempts := 0
+	f := func(c context.Context) (interface{}, ClientError) {
+		if c != ctx {
+			t.Fatal(`Attempt did not receive the retry context`)
+		}
+		attempts++
+		cancel()
+		return nil, RetriableError{E: lastErr}
+	}
+
+	pw := &JitteredBackoff{
+		TTL:     time.Duration(1) * time.Minute,
+		Initial: time.Duration(1) * time.Minute,
+		Jf:      NoJitter,
+		Bof:     ConstantBackoff,
+	}
+
+	t0 := time.Now()
+	_, e := RetryContext(ctx, f, pw)
+	if time.Since(t0) > time.Duration(1)*time.Second {
+		t.Fatal(`RetryContext kept waiting after the context was cancelled`)
+	}
+	if attempts != 1 {
+		t.Fatalf(`Expected 1 attempt, got %v`, attempts)
+	}
+	var ce CancelledError
+	if !errors.As(e, &ce) {
+		t.Fatalf(`Expected a CancelledError, got %v`, e)
+	}
+	var re RetryError
+	if !errors.As(e, &re) || re.Reason != StopCancelled {
+		t.Fatalf(`Expected a cancelled RetryError, got %v`, e)
+	}
+	if ce.Err != context.Canceled {
+		t.Fatal(`CancelledError did not carry the context error`)
+	}
+	if ce.Last != lastErr {
+		t.Fatal(`CancelledError did not carry the last attempt error`)
+	}
+}
+
+func TestRetryContextAlreadyDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f := func(context.Context) (interface{}, ClientError) {
+		t.Fatal(`Attempt made with a done context`)
+		return nil, nil
+	}
+	_, e := RetryContext(ctx, f, &JitteredBackoff{Bof: NoBackoff, Jf: NoJitter})
+	if !errors.As(e, new(CancelledError)) {
+		t.Fatalf(`Expected a CancelledError, got %v`, e)
+	}
+}
+
+// Waiters that only implement PerishableWaiter are abandoned when the context is done.
+func TestRetryContextPlainWaiter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10)*time.Millisecond)
+	defer cancel()
+	f := func(context.Context) (interface{}, ClientError) {
+		return nil, RetriableError{E: errors.New(`retriable`)}
+	}
+	_, e := RetryContext(ctx, f, &blockingWaiter{})
+	if !errors.As(e, new(CancelledError)) || !errors.Is(e, context.DeadlineExceeded) {
+		t.Fatalf(`Expected a CancelledError with DeadlineExceeded, got %v`, e)
+	}
+}
+
+type blockingWaiter struct{}
+
+func (b *blockingWaiter) Start()        {}
+func (b *blockingWaiter) IsDying() bool { return true }
+func (b *blockingWaiter) WaitOrDie(e error) error {
+	time.Sleep(time.Duration(1) * time.Second)
+	return e
+}
+
+func TestRetryContextAttemptTimeout(t *testing.T) {
+	attempts := 0
+	f := func(ctx context.Context) (interface{}, ClientError) {
+		attempts++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Fatal(`Attempt context had no deadline`)
+		}
+		if attempts < 3 {
+			<-ctx.Done()
+			return nil, NonRetriableError{E: ctx.Err()}
+		}
+		return attempts, nil
+	}
+	pw := &JitteredBackoff{
+		TTL:            time.Duration(1) * time.Second,
+		AttemptTimeout: time.Duration(5) * time.Millisecond,
+		Jf:             NoJitter,
+		Bof:            NoBackoff,
+	}
+	r, e := RetryContext(context.Background(), f, pw)
+	if e != nil {
+		t.Fatalf(`Timed out attempts were not retried: %v`, e)
+	}
+	if r != 3 {
+		t.Fatalf(`Expected the result of the third attempt, got %v`, r)
+	}
+}
+
+func TestJitteredBackoffAttemptDeadline(t *testing.T) {
+	pw := &JitteredBackoff{TTL: time.Duration(1) * time.Hour}
+	if _, ok := pw.AttemptDeadline(); ok {
+		t.Fatal(`Attempt deadline reported without an AttemptTimeout`)
+	}
+
+	pw.AttemptTimeout = time.Duration(1) * time.Minute
+	pw.Start()
+	if d, ok := pw.AttemptDeadline(); !ok || d.After(time.Now().Add(pw.AttemptTimeout)) {
+		t.Fatalf(`Attempt deadline %v exceeds the AttemptTimeout`, d)
+	}
+
+	pw.TTL = time.Duration(1) * time.Second
+	pw.Start()
+	if d, ok := pw.AttemptDeadline(); !ok || d.After(time.Now().Add(pw.TTL)) {
+		t.Fatalf(`Attempt deadline %v exceeds the remaining TTL`, d)
+	}
+}
+
+func TestRetryErrorReasons(t *testing.T) {
+	f := func() (interface{}, ClientError) {
+		return nil, RetriableError{E: errors.New(`retriable`)}
+	}
+	reasonOf := func(e error) StopReason {
+		var re RetryError
+		if !errors.As(e, &re) {
+			t.Fatalf(`Failed to return a RetryError: %v`, e)
+		}
+		return re.Reason
+	}
+
+	_, e := Retry(f, &JitteredBackoff{TTL: time.Duration(1) * time.Millisecond, Bof: NoBackoff, Jf: NoJitter})
+	if r := reasonOf(e); r != StopExpired {
+		t.Fatalf(`Expected %v, got %v`, StopExpired, r)
+	}
+
+	_, e = RetryPeriodicN(f, 3, time.Duration(1)*time.Minute, time.Duration(0), time.Duration(0))
+	if r := reasonOf(e); r != StopExhausted {
+		t.Fatalf(`Expected %v, got %v`, StopExhausted, r)
+	}
+
+	_, e = Retry(f, AllOf(&MaxAttempts{N: 100}, &JitteredBackoff{Bof: NoBackoff, Jf: NoJitter}))
+	if r := reasonOf(e); r != StopExpired {
+		t.Fatalf(`Expected %v from the stopping component, got %v`, StopExpired, r)
+	}
+}
+
+func TestJitteredBackoffDelayHint(t *testing.T) {
+	pw := &JitteredBackoff{
+		TTL:     time.Duration(1) * time.Hour,
+		Initial: time.Duration(1) * time.Second,
+		Bof:     ConstantBackoff,
+		Jf:      NoJitter,
+	}
+	pw.Start()
+	hinted := RetriableError{E: HTTPError{StatusCode: 503, RetryAfter: time.Duration(1) * time.Minute}}
+	if d, e := pw.Next(hinted); e != nil || d != time.Duration(1)*time.Minute {
+		t.Fatalf(`Expected to wait for the hinted delay, got %v`, d)
+	}
+	short := RetriableError{E: HTTPError{StatusCode: 503, RetryAfter: time.Duration(1)}}
+	if d, e := pw.Next(short); e != nil || d != time.Duration(1)*time.Second {
+		t.Fatalf(`Expected to ignore a hint shorter than the backoff, got %v`, d)
+	}
+	long := RetriableError{E: HTTPError{StatusCode: 503, RetryAfter: time.Duration(2) * time.Hour}}
+	if _, e := pw.Next(long); e != long {
+		t.Fatal(`Failed to give up on a hint that outlives the TTL`)
+	}
+}
+
+func TestJitteredBackoffStrategy(t *testing.T) {
+	var seen []BackoffState
+	pw := &JitteredBackoff{
+		TTL:     time.Duration(1) * time.Hour,
+		Initial: time.Duration(10),
+		Bof:     ExponentialBackoff,
+		Strategy: func(s BackoffState) time.Duration {
+			seen = append(seen, s)
+			return s.Base + time.Duration(1)
+		},
+	}
+	pw.Start()
+	pw.Next(nil)
+	pw.Next(nil)
+	if len(seen) != 2 {
+		t.Fatalf(`Expected the strategy to be used twice, got %v`, len(seen))
+	}
+	if seen[0].Round != 0 || seen[0].Base != time.Duration(10) || seen[0].Previous != 0 {
+		t.Fatalf(`Unexpected first state %+v`, seen[0])
+	}
+	if seen[1].Round != 1 || seen[1].Base != time.Duration(20) || seen[1].Previous != time.Duration(11) {
+		t.Fatalf(`Unexpected second state %+v`, seen[1])
+	}
+}
+
+func TestJitteredBackoffMaxDelay(t *testing.T) {
+	pw := &JitteredBackoff{
+		TTL:       time.Duration(1) * time.Hour,
+		Initial:   time.Duration(10),
+		MaxJitter: time.Duration(5),
+		MaxDelay:  time.Duration(35),
+		Bof:       ExponentialBackoff,
+		Jf:        func(max time.Duration) time.Duration { return max },
+	}
+	pw.Start()
+	// the cap applies to the backoff, jitter is added on top
+	for _, want := range []time.Duration{15, 25, 40, 40, 40} {
+		if d, err := pw.Next(nil); err != nil || d != want {
+			t.Fatalf(`Expected %v, got %v, %v`, want, d, err)
+		}
+	}
+
+	pw = &JitteredBackoff{
+		TTL:     time.Duration(1) * time.Hour,
+		Initial: time.Duration(1) * time.Millisecond,
+		Bof:     ExponentialBackoff,
+		Jf:      NoJitter,
+	}
+	pw.Start()
+	// a long loop stays at the longest delay rather than starting over
+	var last time.Duration
+	for i := 0; i < 100; i++ {
+		d, _ := pw.Next(nil)
+		if d < last {
+			t.Fatalf(`Delay shrank from %v to %v in round %v`, last, d, i)
+		}
+		last = d
+	}
+}
+
+func TestJitteredBackoffStartResetsRound(t *testing.T) {
+	pw := &JitteredBackoff{
+		TTL:     time.Duration(1) * time.Hour,
+		Initial: time.Duration(10),
+		Bof:     ExponentialBackoff,
+		Jf:      NoJitter,
+	}
+	pw.Start()
+	pw.Next(nil)
+	pw.Next(nil)
+	pw.Start()
+	if d, _ := pw.Next(nil); d != time.Duration(10) {
+		t.Fatalf(`Expected Start to reset the backoff to %v, got %v`, time.Duration(10), d)
+	}
+}
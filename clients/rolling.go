@@ -0,0 +1,71 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"time"
+)
+
+const rollingBuckets = 10
+
+// rollingCounts tallies a pair of counters over a sliding window split into ten buckets.
+type rollingCounts struct {
+	buckets [rollingBuckets]rollingBucket
+}
+
+type rollingBucket struct {
+	start time.Time
+	a, b  uint
+}
+
+// add records a and b in the bucket covering now, recycling the oldest bucket when none
+// does.
+func (r *rollingCounts) add(now time.Time, window time.Duration, a, b uint) {
+	width := window / rollingBuckets
+	if width <= 0 {
+		width = 1
+	}
+	start := now.Truncate(width)
+	bk := &r.buckets[0]
+	for i := range r.buckets {
+		if r.buckets[i].start.Equal(start) {
+			bk = &r.buckets[i]
+			break
+		}
+		if r.buckets[i].start.Before(bk.start) {
+			bk = &r.buckets[i]
+		}
+	}
+	if !bk.start.Equal(start) {
+		*bk = rollingBucket{start: start}
+	}
+	bk.a += a
+	bk.b += b
+}
+
+// sum returns the totals of the buckets that started within window of now.
+func (r *rollingCounts) sum(now time.Time, window time.Duration) (a, b uint) {
+	for _, bk := range r.buckets {
+		if now.Sub(bk.start) < window {
+			a += bk.a
+			b += bk.b
+		}
+	}
+	return a, b
+}
+
+func (r *rollingCounts) reset() {
+	r.buckets = [rollingBuckets]rollingBucket{}
+}
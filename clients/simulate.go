@@ -0,0 +1,228 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Schedule is the result of simulating a Policy. Rounds[i] describes the wait after the
+// (i+1)th failed attempt over every sample that got that far.
+type Schedule struct {
+	Samples int
+	Rounds  []Round
+	// Attempts and Elapsed describe how many attempts each sample made and how long it
+	// took to give up, assuming every attempt fails instantly.
+	Attempts CountEnvelope
+	Elapsed  Envelope
+	// Truncated is set if a sample was still retrying when the simulation stopped it.
+	Truncated bool
+}
+
+// Round describes one wait of a Schedule. Reached counts the samples that made the wait,
+// Delay is the length of the wait and Elapsed is the time from the first attempt until
+// the end of the wait. GaveUp counts the samples whose wait was cut short by the deadline
+// and ended in giving up rather than another attempt.
+type Round struct {
+	Reached int
+	GaveUp  int
+	Delay   Envelope
+	Elapsed Envelope
+}
+
+// Envelope summarizes a set of durations by their extremes and percentiles.
+type Envelope struct {
+	Min, P50, P90, P99, Max time.Duration
+}
+
+// CountEnvelope summarizes a set of counts by their extremes and percentiles.
+type CountEnvelope struct {
+	Min, P50, P90, P99, Max int
+}
+
+// simulationRounds bounds every sample, so a policy that never gives up still ends.
+const simulationRounds = 10000
+
+var errSimulated = Retriable(errors.New(`simulated failure`))
+
+// Simulate runs samples calls of p on virtual time, failing every attempt instantly, and
+// reports the delays between attempts. It returns at once: nothing sleeps. The Clock of
+// p is ignored. Seed the jitter with NewRandom for a reproducible schedule:
+//
+//	r := clients.NewRandom(rand.NewSource(1))
+//	s := clients.Simulate(clients.Policy{TTL: ttl, Initial: initial, MaxJitter: jitter, Jf: r.Jitter}, 1000)
+//
+// A Policy without jitter has the same schedule on every sample, so one is enough.
+func Simulate(p Policy, samples int) Schedule {
+	s := Schedule{Samples: samples}
+	if samples <= 0 {
+		return s
+	}
+	var delays, elapsed [][]time.Duration
+	var gaveUp []int
+	attempts := make([]int, 0, samples)
+	totals := make([]time.Duration, 0, samples)
+	for i := 0; i < samples; i++ {
+		c := &virtualClock{now: time.Unix(0, 0)}
+		p.Clock = c
+		w := p.NewWaiter().(Scheduler)
+		w.Start()
+		start := c.now
+		made := 1
+		for round := 0; ; round++ {
+			if round == simulationRounds {
+				s.Truncated = true
+				break
+			}
+			d, err := w.Next(errSimulated)
+			if err != nil {
+				break
+			}
+			// like sleep in RetryContext, a wait that would outlast the deadline ends at the
+			// deadline and gives up
+			dies := false
+			if deadline, ok := w.Deadline(); ok {
+				remaining := deadline.Sub(c.now)
+				if remaining <= 0 {
+					break
+				}
+				if remaining < d {
+					d, dies = remaining, true
+				}
+			}
+			c.now = c.now.Add(d)
+			if round == len(delays) {
+				delays = append(delays, nil)
+				elapsed = append(elapsed, nil)
+				gaveUp = append(gaveUp, 0)
+			}
+			delays[round] = append(delays[round], d)
+			elapsed[round] = append(elapsed[round], c.now.Sub(start))
+			if dies {
+				gaveUp[round]++
+				break
+			}
+			made++
+		}
+		attempts = append(attempts, made)
+		totals = append(totals, c.now.Sub(start))
+	}
+	for i := range delays {
+		s.Rounds = append(s.Rounds, Round{
+			Reached: len(delays[i]),
+			GaveUp:  gaveUp[i],
+			Delay:   envelope(delays[i]),
+			Elapsed: envelope(elapsed[i]),
+		})
+	}
+	s.Attempts = countEnvelope(attempts)
+	s.Elapsed = envelope(totals)
+	return s
+}
+
+// String formats the schedule as a table with one line per round.
+func (s Schedule) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d samples, %v to %v attempts, giving up after %v to %v\n",
+		s.Samples, s.Attempts.Min, s.Attempts.Max, s.Elapsed.Min, s.Elapsed.Max)
+	for i, r := range s.Rounds {
+		fmt.Fprintf(&b, "%3d %6d  delay %v [%v, %v]  elapsed %v [%v, %v]",
+			i+1, r.Reached, r.Delay.P50, r.Delay.Min, r.Delay.Max, r.Elapsed.P50, r.Elapsed.Min, r.Elapsed.Max)
+		if r.GaveUp > 0 {
+			fmt.Fprintf(&b, "  %d gave up", r.GaveUp)
+		}
+		b.WriteByte('\n')
+	}
+	if s.Truncated {
+		fmt.Fprintf(&b, "truncated after %d rounds\n", simulationRounds)
+	}
+	return b.String()
+}
+
+// envelope sorts ds and summarizes it using nearest rank percentiles.
+func envelope(ds []time.Duration) Envelope {
+	if len(ds) == 0 {
+		return Envelope{}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return Envelope{
+		Min: ds[0],
+		P50: ds[rank(50, len(ds))],
+		P90: ds[rank(90, len(ds))],
+		P99: ds[rank(99, len(ds))],
+		Max: ds[len(ds)-1],
+	}
+}
+
+// countEnvelope sorts ns and summarizes it using nearest rank percentiles.
+func countEnvelope(ns []int) CountEnvelope {
+	if len(ns) == 0 {
+		return CountEnvelope{}
+	}
+	sort.Ints(ns)
+	return CountEnvelope{
+		Min: ns[0],
+		P50: ns[rank(50, len(ns))],
+		P90: ns[rank(90, len(ns))],
+		P99: ns[rank(99, len(ns))],
+		Max: ns[len(ns)-1],
+	}
+}
+
+// rank returns the index of the nearest rank p percentile of n sorted values.
+func rank(p, n int) int {
+	i := (p*n+99)/100 - 1
+	if i < 0 {
+		return 0
+	}
+	return i
+}
+
+// virtualClock is the Clock of a simulation. Time only moves when the simulation moves it,
+// or when something waits on it, in which case the wait ends at once.
+type virtualClock struct {
+	now time.Time
+}
+
+func (v *virtualClock) Now() time.Time {
+	return v.now
+}
+func (v *virtualClock) After(d time.Duration) <-chan time.Time {
+	return v.NewTimer(d).C()
+}
+func (v *virtualClock) NewTimer(d time.Duration) Timer {
+	if d > 0 {
+		v.now = v.now.Add(d)
+	}
+	c := make(chan time.Time, 1)
+	c <- v.now
+	return virtualTimer(c)
+}
+
+type virtualTimer chan time.Time
+
+func (t virtualTimer) C() <-chan time.Time {
+	return t
+}
+func (t virtualTimer) Stop() bool {
+	return false
+}
+func (t virtualTimer) Reset(d time.Duration) bool {
+	return false
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSimulateExact(t *testing.T) {
+	ms := time.Millisecond
+	s := Simulate(Policy{TTL: 1000 * ms, Initial: 10 * ms, Jf: NoJitter}, 1)
+	// the last wait of 640ms is cut short by the TTL, as in TestJitteredBackoffSchedule
+	want := []time.Duration{10 * ms, 20 * ms, 40 * ms, 80 * ms, 160 * ms, 320 * ms, 370 * ms}
+	if len(s.Rounds) != len(want) {
+		t.Fatalf(`Expected %v rounds, got %v`, len(want), s)
+	}
+	var elapsed time.Duration
+	for i, r := range s.Rounds {
+		elapsed += want[i]
+		if r.Reached != 1 || r.Delay.Min != want[i] || r.Delay.Max != want[i] || r.Elapsed.P50 != elapsed {
+			t.Fatalf(`Unexpected round %v: %+v`, i, r)
+		}
+		if gaveUp := i == len(want)-1; (r.GaveUp == 1) != gaveUp {
+			t.Fatalf(`Unexpected GaveUp in round %v: %+v`, i, r)
+		}
+	}
+	if s.Attempts.Max != 7 || s.Elapsed.Max != 1000*ms {
+		t.Fatalf(`Expected 7 attempts over 1s, got %v`, s)
+	}
+}
+
+func TestSimulateMaxAttempts(t *testing.T) {
+	s := Simulate(Policy{TTL: time.Hour, Initial: time.Millisecond, MaxDelay: 4 * time.Millisecond, MaxAttempts: 5, Jf: NoJitter}, 3)
+	if len(s.Rounds) != 4 || s.Attempts.Min != 5 || s.Attempts.Max != 5 {
+		t.Fatalf(`Expected 5 attempts, got %v`, s)
+	}
+	if r := s.Rounds[3]; r.Reached != 3 || r.Delay.P99 != 4*time.Millisecond {
+		t.Fatalf(`Expected the last delay to be capped, got %+v`, r)
+	}
+}
+
+func TestSimulateEnvelope(t *testing.T) {
+	p := Policy{
+		TTL:       time.Second,
+		Initial:   10 * time.Millisecond,
+		MaxJitter: 10 * time.Millisecond,
+		Bof:       ConstantBackoff,
+		Jf:        NewRandom(rand.NewSource(1)).Jitter,
+	}
+	s := Simulate(p, 200)
+	if s.Samples != 200 || len(s.Rounds) == 0 {
+		t.Fatalf(`Unexpected schedule %v`, s)
+	}
+	r := s.Rounds[0]
+	if r.Reached != 200 {
+		t.Fatalf(`Expected every sample to reach the first round, got %v`, r.Reached)
+	}
+	e := r.Delay
+	if e.Min < 10*time.Millisecond || e.Max >= 20*time.Millisecond ||
+		e.Min > e.P50 || e.P50 > e.P90 || e.P90 > e.P99 || e.P99 > e.Max || e.Min == e.Max {
+		t.Fatalf(`Unexpected envelope %+v`, e)
+	}
+	if s.Elapsed.Max > time.Second {
+		t.Fatalf(`Simulation outlived the TTL: %v`, s.Elapsed.Max)
+	}
+
+	// the same seed gives the same schedule
+	p.Jf = NewRandom(rand.NewSource(1)).Jitter
+	if o := Simulate(p, 200); !reflect.DeepEqual(o, s) {
+		t.Fatal(`Simulations with the same seed differ`)
+	}
+}
+
+func TestSimulateTruncated(t *testing.T) {
+	s := Simulate(Policy{TTL: time.Minute, Bof: NoBackoff, Jf: NoJitter}, 1)
+	if !s.Truncated || len(s.Rounds) != simulationRounds {
+		t.Fatalf(`Expected a truncated schedule, got %v rounds`, len(s.Rounds))
+	}
+	if s := Simulate(Policy{}, 0); s.Samples != 0 || len(s.Rounds) != 0 {
+		t.Fatalf(`Expected an empty schedule, got %v`, s)
+	}
+}
@@ -15,13 +15,32 @@
 package clients
 
 import (
+	"math"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type BackoffFunc func(r uint, t time.Duration) time.Duration
 type JitterFunc func(t time.Duration) time.Duration
 
+// BackoffState is what a DelayStrategy sees when it chooses the delay before the next
+// attempt. Base is the delay computed by the BackoffFunc for this round and Previous is the
+// delay chosen for the previous round, or zero before the first retry. Base is already
+// capped by MaxDelay, which is zero when there is no cap.
+type BackoffState struct {
+	Round     uint
+	Initial   time.Duration
+	Base      time.Duration
+	Previous  time.Duration
+	MaxJitter time.Duration
+	MaxDelay  time.Duration
+}
+
+// DelayStrategy chooses the delay before the next attempt.
+type DelayStrategy func(s BackoffState) time.Duration
+
 func NoBackoff(round uint, zero time.Duration) time.Duration {
 	return time.Duration(0)
 }
@@ -33,27 +52,186 @@ func ConstantBackoff(round uint, flat time.Duration) time.Duration {
 	return flat
 }
 
+// LinearBackoff grows the delay by initial every round. It saturates at the longest
+// representable duration rather than overflowing.
 func LinearBackoff(round uint, initial time.Duration) time.Duration {
 	if initial <= 0 {
 		return time.Duration(0)
 	}
-	return time.Duration(round+1) * initial
+	return scale(initial, uint64(round)+1)
 }
 
+// ExponentialBackoff doubles the delay every round. It saturates at the longest
+// representable duration rather than overflowing.
 func ExponentialBackoff(round uint, initial time.Duration) time.Duration {
 	if initial <= 0 {
 		return time.Duration(0)
 	}
-	r := initial << round
-	if r < 0 {
-		return initial
+	if round >= 63 || initial > time.Duration(math.MaxInt64>>round) {
+		return time.Duration(math.MaxInt64)
+	}
+	return initial << round
+}
+
+// FibonacciBackoff multiplies initial by the Fibonacci sequence 1, 1, 2, 3, 5... It grows
+// more gently than ExponentialBackoff and saturates at the longest representable duration.
+func FibonacciBackoff(round uint, initial time.Duration) time.Duration {
+	if initial <= 0 {
+		return time.Duration(0)
+	}
+	var a, b uint64 = 1, 1
+	for i := uint(0); i < round; i++ {
+		// past the 92nd number the sequence no longer fits and the delay has long saturated
+		if b > math.MaxInt64-a {
+			return time.Duration(math.MaxInt64)
+		}
+		a, b = b, a+b
+	}
+	return scale(initial, a)
+}
+
+// PolynomialBackoff returns a BackoffFunc that multiplies initial by (round+1)^exp.
+// PolynomialBackoff(1) is LinearBackoff and PolynomialBackoff(2) grows quadratically. The
+// delay saturates at the longest representable duration.
+func PolynomialBackoff(exp uint) BackoffFunc {
+	return func(round uint, initial time.Duration) time.Duration {
+		if initial <= 0 {
+			return time.Duration(0)
+		}
+		d := initial
+		for i := uint(0); i < exp && d < time.Duration(math.MaxInt64); i++ {
+			d = scale(d, uint64(round)+1)
+		}
+		return d
+	}
+}
+
+// scale multiplies a positive duration by n, saturating at the longest representable
+// duration. An n of zero is taken to have wrapped around from the largest round.
+func scale(d time.Duration, n uint64) time.Duration {
+	if n == 0 || uint64(d) > math.MaxInt64/n {
+		return time.Duration(math.MaxInt64)
 	}
-	return time.Duration(r)
+	return d * time.Duration(n)
 }
 
+// Jitter picks a delay uniformly between zero and max, at nanosecond resolution.
 func Jitter(max time.Duration) time.Duration {
+	return defaultRandom.Jitter(max)
+}
+
+// AdditiveJitter returns the DelayStrategy JitteredBackoff uses when it has none: the base
+// delay plus jf(MaxJitter).
+func AdditiveJitter(jf JitterFunc) DelayStrategy {
+	return func(s BackoffState) time.Duration {
+		d := s.Base + jf(s.MaxJitter)
+		if d < s.Base {
+			return time.Duration(math.MaxInt64)
+		}
+		return d
+	}
+}
+
+// FullJitter picks a delay uniformly between zero and the base delay.
+func FullJitter(s BackoffState) time.Duration {
+	return defaultRandom.FullJitter(s)
+}
+
+// EqualJitter keeps half of the base delay and picks the other half uniformly.
+func EqualJitter(s BackoffState) time.Duration {
+	return defaultRandom.EqualJitter(s)
+}
+
+// DecorrelatedJitter picks a delay uniformly between the initial delay and three times the
+// previous delay, bounded by MaxDelay when there is one. It grows on its own and ignores
+// the base delay, so pair it with a BackoffFunc that is cheap to compute.
+func DecorrelatedJitter(s BackoffState) time.Duration {
+	return defaultRandom.DecorrelatedJitter(s)
+}
+
+// Random is a source of randomness for jitter. Its methods match JitterFunc and
+// DelayStrategy and are safe for concurrent use.
+//
+// A Random made with NewRandom draws from its own source under its own lock, so a seeded
+// source gives a reproducible stream and instances do not contend with each other. The
+// zero value draws from a pool of sources seeded from the time, which is what the package
+// level jitter functions use.
+type Random struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// NewRandom returns a Random that draws from src.
+func NewRandom(src rand.Source) *Random {
+	return &Random{r: rand.New(src)}
+}
+
+var defaultRandom = &Random{}
+
+var (
+	seeds   int64
+	sources = sync.Pool{
+		New: func() interface{} {
+			return rand.New(rand.NewSource(time.Now().UnixNano() + atomic.AddInt64(&seeds, 1)))
+		},
+	}
+)
+
+// int63n returns a number in [0, n).
+func (r *Random) int63n(n int64) int64 {
+	if r.r == nil {
+		pr := sources.Get().(*rand.Rand)
+		v := pr.Int63n(n)
+		sources.Put(pr)
+		return v
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Int63n(n)
+}
+
+// Jitter picks a delay uniformly between zero and max.
+func (r *Random) Jitter(max time.Duration) time.Duration {
 	if max <= 0 {
 		return time.Duration(0)
 	}
-	return time.Duration(rand.Intn(int(max/time.Millisecond))) * time.Millisecond
+	return time.Duration(r.int63n(int64(max)))
+}
+
+func (r *Random) FullJitter(s BackoffState) time.Duration {
+	if s.Base <= 0 {
+		return time.Duration(0)
+	}
+	if s.Base == time.Duration(math.MaxInt64) {
+		return time.Duration(r.int63n(int64(s.Base)))
+	}
+	return time.Duration(r.int63n(int64(s.Base) + 1))
+}
+
+func (r *Random) EqualJitter(s BackoffState) time.Duration {
+	if s.Base <= 0 {
+		return time.Duration(0)
+	}
+	half := s.Base / 2
+	return s.Base - half + time.Duration(r.int63n(int64(half)+1))
+}
+
+func (r *Random) DecorrelatedJitter(s BackoffState) time.Duration {
+	if s.Initial <= 0 {
+		return time.Duration(0)
+	}
+	upper := s.Previous * 3
+	if s.Previous > math.MaxInt64/3 {
+		upper = time.Duration(math.MaxInt64)
+	}
+	if s.MaxDelay > 0 && upper > s.MaxDelay {
+		upper = s.MaxDelay
+	}
+	if s.MaxDelay > 0 && s.Initial >= s.MaxDelay {
+		return s.MaxDelay
+	}
+	if upper <= s.Initial {
+		return s.Initial
+	}
+	return s.Initial + time.Duration(r.int63n(int64(upper-s.Initial)))
 }
@@ -15,6 +15,9 @@
 package clients
 
 import (
+	"math"
+	"math/rand"
+	"sync"
 	"testing"
 	"time"
 )
@@ -86,9 +89,181 @@ func TestExponentialBackoff(t *testing.T) {
 	if r := ExponentialBackoff(3, time.Duration(1)); r != time.Duration(8) {
 		t.Fatalf(`Returned %v instead of %v with round: 3 and initial: %v`, r, time.Duration(8), time.Duration(1))
 	}
-	if r := ExponentialBackoff(1, time.Duration(1<<62)); r != time.Duration(1<<62) {
-		t.Fatalf(`Returned %v instead of %v with round: 1 and initial: %v`, r, time.Duration(1<<62), time.Duration(1<<62))
+	// saturate rather than falling back to initial
+	if r := ExponentialBackoff(1, time.Duration(1<<62)); r != time.Duration(math.MaxInt64) {
+		t.Fatalf(`Returned %v instead of %v with round: 1 and initial: %v`, r, time.Duration(math.MaxInt64), time.Duration(1<<62))
+	}
+	if r := ExponentialBackoff(63, time.Duration(1)); r != time.Duration(math.MaxInt64) {
+		t.Fatalf(`Returned %v instead of %v with round: 63 and initial: %v`, r, time.Duration(math.MaxInt64), time.Duration(1))
+	}
+	if r := ExponentialBackoff(1000, time.Millisecond); r != time.Duration(math.MaxInt64) {
+		t.Fatalf(`Returned %v instead of %v with round: 1000 and initial: %v`, r, time.Duration(math.MaxInt64), time.Millisecond)
+	}
+}
+
+func TestLinearBackoffSaturates(t *testing.T) {
+	if r := LinearBackoff(1, time.Duration(1<<62)); r != time.Duration(math.MaxInt64) {
+		t.Fatalf(`Returned %v instead of %v with round: 1 and initial: %v`, r, time.Duration(math.MaxInt64), time.Duration(1<<62))
+	}
+	if r := LinearBackoff(^uint(0), time.Hour); r != time.Duration(math.MaxInt64) {
+		t.Fatalf(`Returned %v instead of %v with round: max and initial: %v`, r, time.Duration(math.MaxInt64), time.Hour)
+	}
+}
+
+func TestFibonacciBackoff(t *testing.T) {
+	if r := FibonacciBackoff(0, time.Duration(-1)); r != time.Duration(0) {
+		t.Fatalf(`Returned %v instead of %v with round: 0 and initial: %v`, r, time.Duration(0), time.Duration(-1))
+	}
+	for round, want := range []time.Duration{1, 1, 2, 3, 5, 8, 13} {
+		if r := FibonacciBackoff(uint(round), time.Duration(10)); r != want*10 {
+			t.Fatalf(`Returned %v instead of %v with round: %v and initial: %v`, r, want*10, round, time.Duration(10))
+		}
+	}
+	if r := FibonacciBackoff(100, time.Duration(1)); r != time.Duration(math.MaxInt64) {
+		t.Fatalf(`Returned %v instead of %v with round: 100 and initial: %v`, r, time.Duration(math.MaxInt64), time.Duration(1))
+	}
+	if r := FibonacciBackoff(70, time.Millisecond); r != time.Duration(math.MaxInt64) {
+		t.Fatalf(`Returned %v instead of %v with round: 70 and initial: %v`, r, time.Duration(math.MaxInt64), time.Millisecond)
+	}
+}
+
+func TestPolynomialBackoff(t *testing.T) {
+	if r := PolynomialBackoff(2)(0, time.Duration(-1)); r != time.Duration(0) {
+		t.Fatalf(`Returned %v instead of %v with round: 0 and initial: %v`, r, time.Duration(0), time.Duration(-1))
+	}
+	for round := uint(0); round < 5; round++ {
+		if r, l := PolynomialBackoff(1)(round, time.Duration(3)), LinearBackoff(round, time.Duration(3)); r != l {
+			t.Fatalf(`Linear polynomial returned %v instead of %v with round: %v`, r, l, round)
+		}
+	}
+	for round, want := range []time.Duration{1, 8, 27, 64} {
+		if r := PolynomialBackoff(3)(uint(round), time.Duration(2)); r != want*2 {
+			t.Fatalf(`Returned %v instead of %v with round: %v and initial: %v`, r, want*2, round, time.Duration(2))
+		}
+	}
+	if r := PolynomialBackoff(0)(7, time.Duration(5)); r != time.Duration(5) {
+		t.Fatalf(`Returned %v instead of %v with exponent: 0`, r, time.Duration(5))
+	}
+	if r := PolynomialBackoff(4)(1<<20, time.Second); r != time.Duration(math.MaxInt64) {
+		t.Fatalf(`Returned %v instead of %v with round: %v`, r, time.Duration(math.MaxInt64), 1<<20)
 	}
 }
 
-func TestJitter(t *testing.T) {}
+func TestJitter(t *testing.T) {
+	if r := Jitter(time.Duration(0)); r != time.Duration(0) {
+		t.Fatalf(`Returned %v instead of 0 with max: 0`, r)
+	}
+	if r := Jitter(time.Duration(-1)); r != time.Duration(0) {
+		t.Fatalf(`Returned %v instead of 0 with max: -1`, r)
+	}
+	// below a millisecond
+	max := time.Duration(500) * time.Nanosecond
+	nonZero := false
+	for i := 0; i < 1000; i++ {
+		r := Jitter(max)
+		if r < 0 || r >= max {
+			t.Fatalf(`Returned %v outside [0, %v)`, r, max)
+		}
+		nonZero = nonZero || r > 0
+	}
+	if !nonZero {
+		t.Fatal(`Sub-millisecond jitter was always zero`)
+	}
+}
+
+func TestRandomSeeded(t *testing.T) {
+	a := NewRandom(rand.NewSource(42))
+	b := NewRandom(rand.NewSource(42))
+	s := BackoffState{Initial: time.Duration(10), Base: time.Duration(1000), Previous: time.Duration(100)}
+	for i := 0; i < 100; i++ {
+		if a.Jitter(time.Second) != b.Jitter(time.Second) ||
+			a.FullJitter(s) != b.FullJitter(s) ||
+			a.EqualJitter(s) != b.EqualJitter(s) ||
+			a.DecorrelatedJitter(s) != b.DecorrelatedJitter(s) {
+			t.Fatal(`Randoms with the same seed diverged`)
+		}
+	}
+}
+
+func TestRandomConcurrent(t *testing.T) {
+	r := NewRandom(rand.NewSource(1))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				r.Jitter(time.Millisecond)
+				Jitter(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFullJitter(t *testing.T) {
+	if r := FullJitter(BackoffState{Base: time.Duration(0)}); r != time.Duration(0) {
+		t.Fatalf(`Returned %v instead of 0 with base: 0`, r)
+	}
+	base := time.Duration(100)
+	for i := 0; i < 1000; i++ {
+		if r := FullJitter(BackoffState{Base: base}); r < 0 || r > base {
+			t.Fatalf(`Returned %v outside [0, %v]`, r, base)
+		}
+	}
+}
+
+func TestEqualJitter(t *testing.T) {
+	if r := EqualJitter(BackoffState{Base: time.Duration(-1)}); r != time.Duration(0) {
+		t.Fatalf(`Returned %v instead of 0 with base: -1`, r)
+	}
+	base := time.Duration(101)
+	for i := 0; i < 1000; i++ {
+		if r := EqualJitter(BackoffState{Base: base}); r < base-base/2 || r > base {
+			t.Fatalf(`Returned %v outside [%v, %v]`, r, base-base/2, base)
+		}
+	}
+}
+
+func TestDecorrelatedJitter(t *testing.T) {
+	initial := time.Duration(10)
+	if r := DecorrelatedJitter(BackoffState{Initial: initial}); r != initial {
+		t.Fatalf(`Returned %v instead of the initial delay before the first retry`, r)
+	}
+	previous := time.Duration(40)
+	for i := 0; i < 1000; i++ {
+		if r := DecorrelatedJitter(BackoffState{Initial: initial, Previous: previous}); r < initial || r >= previous*3 {
+			t.Fatalf(`Returned %v outside [%v, %v)`, r, initial, previous*3)
+		}
+	}
+	if r := DecorrelatedJitter(BackoffState{Initial: initial, Previous: time.Duration(1 << 62)}); r < initial {
+		t.Fatalf(`Returned %v after the upper bound overflowed`, r)
+	}
+}
+
+func TestAdditiveJitter(t *testing.T) {
+	jf := func(max time.Duration) time.Duration { return max }
+	if r := AdditiveJitter(jf)(BackoffState{Base: time.Duration(5), MaxJitter: time.Duration(3)}); r != time.Duration(8) {
+		t.Fatalf(`Returned %v instead of %v`, r, time.Duration(8))
+	}
+}
+
+func TestDecorrelatedJitterMaxDelay(t *testing.T) {
+	s := BackoffState{Initial: time.Duration(10), Previous: time.Duration(1000), MaxDelay: time.Duration(50)}
+	for i := 0; i < 100; i++ {
+		if r := DecorrelatedJitter(s); r < s.Initial || r > s.MaxDelay {
+			t.Fatalf(`Returned %v outside [%v, %v]`, r, s.Initial, s.MaxDelay)
+		}
+	}
+	s = BackoffState{Initial: time.Duration(100), Previous: time.Duration(1000), MaxDelay: time.Duration(50)}
+	if r := DecorrelatedJitter(s); r != s.MaxDelay {
+		t.Fatalf(`Returned %v instead of %v with an initial delay above the cap`, r, s.MaxDelay)
+	}
+}
+
+func TestAdditiveJitterSaturates(t *testing.T) {
+	jf := func(max time.Duration) time.Duration { return max }
+	if r := AdditiveJitter(jf)(BackoffState{Base: time.Duration(math.MaxInt64), MaxJitter: time.Duration(3)}); r != time.Duration(math.MaxInt64) {
+		t.Fatalf(`Returned %v instead of %v`, r, time.Duration(math.MaxInt64))
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Transport is an http.RoundTripper that retries requests sent through Base.
+//
+// Every request gets a fresh waiter from NewWaiter and runs under the request's context.
+// Request bodies are replayed on every attempt, using GetBody when the request has it and
+// buffering the body in memory otherwise. Responses superseded by a later attempt are
+// drained and closed by the retry loop, and small failed responses are read into memory
+// before each wait, as described for Retry.
+//
+// Only requests that are safe to repeat are retried: those with an idempotent method
+// (GET, HEAD, OPTIONS, TRACE, PUT and DELETE) and those that already carry an idempotency
+// key header. Setting IdempotencyKeys opts POST and PATCH requests in as well by attaching
+// a new key to each request; every attempt of that request sends the same key so the
+// server can recognise repeats. Other requests are sent once.
+//
+// When the retry loop gives up on a response, RoundTrip returns the last response with a
+// nil error, just as Base would have, and leaves the decision to the caller. It only
+// returns an error when the last attempt failed without a response or the request's
+// context was done.
+type Transport struct {
+	// Base defaults to http.DefaultTransport.
+	Base http.RoundTripper
+	// NewWaiter must return a new waiter on every call, like the NewWaiter method of a
+	// Policy. It defaults to exponential backoff from 50ms with up to 50ms of jitter for 30
+	// seconds.
+	NewWaiter func() PerishableWaiter
+	// Classifier defaults to DefaultHTTPClassifier.
+	Classifier *HTTPClassifier
+	// IdempotencyKeys opts POST and PATCH requests into retries.
+	IdempotencyKeys bool
+	// IdempotencyHeader defaults to Idempotency-Key.
+	IdempotencyHeader string
+	// NewIdempotencyKey defaults to NewIdempotencyKey.
+	NewIdempotencyKey func() string
+}
+
+// DefaultIdempotencyHeader is the header Transport uses for idempotency keys by default.
+const DefaultIdempotencyHeader = `Idempotency-Key`
+
+// IsIdempotent reports whether repeating req has the same effect as sending it once,
+// judging by its method.
+func IsIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case ``, http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// NewIdempotencyKey returns a random version 4 UUID.
+func NewIdempotencyKey() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var pw PerishableWaiter
+	switch {
+	case IsIdempotent(req) || req.Header.Get(t.idempotencyHeader()) != ``:
+		pw = t.newWaiter()
+	case t.IdempotencyKeys && (req.Method == http.MethodPost || req.Method == http.MethodPatch):
+		req = t.withIdempotencyKey(req)
+		pw = t.newWaiter()
+	default:
+		return t.base().RoundTrip(req)
+	}
+
+	first, body, err := replayableBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := 0
+	result, err := RetryContext(req.Context(), func(ctx context.Context) (interface{}, ClientError) {
+		r := req.WithContext(ctx)
+		if attempts++; attempts == 1 {
+			r.Body = first
+		} else if body != nil {
+			rc, err := body()
+			if err != nil {
+				return nil, NonRetriableError{E: err}
+			}
+			r.Body = rc
+		}
+		return t.Classifier.Classify(t.base().RoundTrip(r))
+	}, pw)
+	// a RoundTripper closes the request body even when it never sends it
+	if attempts == 0 && first != nil {
+		first.Close()
+	}
+
+	resp, _ := result.(*http.Response)
+	if err == nil || resp == nil {
+		return resp, err
+	}
+	if errors.As(err, new(CancelledError)) {
+		DrainResponse(resp)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func (t *Transport) idempotencyHeader() string {
+	if t.IdempotencyHeader == `` {
+		return DefaultIdempotencyHeader
+	}
+	return t.IdempotencyHeader
+}
+
+// withIdempotencyKey returns a shallow copy of req with a new idempotency key header.
+func (t *Transport) withIdempotencyKey(req *http.Request) *http.Request {
+	key := NewIdempotencyKey
+	if t.NewIdempotencyKey != nil {
+		key = t.NewIdempotencyKey
+	}
+	r := req.WithContext(req.Context())
+	r.Header = req.Header.Clone()
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	r.Header.Set(t.idempotencyHeader(), key())
+	return r
+}
+
+var defaultTransportPolicy = Policy{
+	TTL:       time.Duration(30) * time.Second,
+	Initial:   time.Duration(50) * time.Millisecond,
+	MaxJitter: time.Duration(50) * time.Millisecond,
+	Bof:       ExponentialBackoff,
+	Jf:        Jitter,
+}
+
+func (t *Transport) newWaiter() PerishableWaiter {
+	if t.NewWaiter == nil {
+		return defaultTransportPolicy.NewWaiter()
+	}
+	return t.NewWaiter()
+}
+
+// replayableBody returns the body for the first attempt and a function that opens a fresh
+// copy of it for later attempts, or nil if the request has no body. Bodies without GetBody
+// are read into memory.
+func replayableBody(req *http.Request) (io.ReadCloser, func() (io.ReadCloser, error), error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req.Body, nil, nil
+	}
+	if req.GetBody != nil {
+		return req.Body, req.GetBody, nil
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	open := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	first, _ := open()
+	return first, open, nil
+}
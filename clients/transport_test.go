@@ -0,0 +1,322 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fastWaiter() PerishableWaiter {
+	return &JitteredBackoff{TTL: time.Duration(1) * time.Second, Bof: NoBackoff, Jf: NoJitter}
+}
+
+func TestTransportReplaysBody(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`try again`))
+			return
+		}
+		w.Write([]byte(`done`))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{NewWaiter: fastWaiter}}
+
+	// a body without GetBody is buffered
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, ioutil.NopCloser(strings.NewReader(`payload`)))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf(`Unexpected error: %v`, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf(`Expected the final successful response, got %v`, resp.StatusCode)
+	}
+	if len(bodies) != 3 {
+		t.Fatalf(`Expected 3 attempts, got %v`, len(bodies))
+	}
+	for i, b := range bodies {
+		if b != `payload` {
+			t.Fatalf(`Attempt %v sent body %q`, i+1, b)
+		}
+	}
+
+	// a body with GetBody is reopened
+	bodies = nil
+	req, _ = http.NewRequest(http.MethodPut, srv.URL, strings.NewReader(`again`))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf(`Unexpected error: %v`, err)
+	}
+	resp.Body.Close()
+	for i, b := range bodies {
+		if b != `again` {
+			t.Fatalf(`Attempt %v sent body %q`, i+1, b)
+		}
+	}
+}
+
+// trackedBody records whether it was closed.
+type trackedBody struct {
+	*strings.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTransportDiscardsSupersededResponses(t *testing.T) {
+	var bodies []*trackedBody
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		b := &trackedBody{Reader: strings.NewReader(`unavailable`)}
+		bodies = append(bodies, b)
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: b, Request: r}, nil
+	})
+	tr := &Transport{
+		Base: base,
+		NewWaiter: func() PerishableWaiter {
+			return &MaxAttempts{N: 3, Waiter: fastWaiter()}
+		},
+	}
+	req, _ := http.NewRequest(http.MethodGet, `http://example.com/`, nil)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf(`Expected the last response rather than an error, got %v`, err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf(`Expected the last response, got %v`, resp.StatusCode)
+	}
+	if len(bodies) != 3 {
+		t.Fatalf(`Expected 3 attempts, got %v`, len(bodies))
+	}
+	for i, b := range bodies[:2] {
+		if !b.closed || b.Len() != 0 {
+			t.Fatalf(`Superseded response %v was not drained and closed`, i+1)
+		}
+	}
+	if b, err := ioutil.ReadAll(resp.Body); err != nil || string(b) != `unavailable` {
+		t.Fatalf(`Expected the returned response to keep its body, got %q, %v`, b, err)
+	}
+}
+
+// checkingWaiter runs check before every wait.
+type checkingWaiter struct {
+	PerishableWaiter
+	check func()
+}
+
+func (c checkingWaiter) WaitOrDie(e error) error {
+	c.check()
+	return c.PerishableWaiter.WaitOrDie(e)
+}
+
+func TestTransportClosesBeforeWaiting(t *testing.T) {
+	var bodies []*trackedBody
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		b := &trackedBody{Reader: strings.NewReader(`unavailable`)}
+		bodies = append(bodies, b)
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: b, Request: r}, nil
+	})
+	waits := 0
+	tr := &Transport{
+		Base: base,
+		NewWaiter: func() PerishableWaiter {
+			return checkingWaiter{PerishableWaiter: &MaxAttempts{N: 3, Waiter: fastWaiter()}, check: func() {
+				waits++
+				if !bodies[len(bodies)-1].closed {
+					t.Fatal(`Waited with the body of the failed response open`)
+				}
+			}}
+		},
+	}
+	req, _ := http.NewRequest(http.MethodGet, `http://example.com/`, nil)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf(`Unexpected error: %v`, err)
+	}
+	resp.Body.Close()
+	if waits != 3 {
+		t.Fatalf(`Expected 3 waits, got %v`, waits)
+	}
+}
+
+func TestTransportReturnsLargeBodyInFull(t *testing.T) {
+	payload := strings.Repeat(`x`, 2*maxDrain)
+	var bodies []*trackedBody
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		b := &trackedBody{Reader: strings.NewReader(payload)}
+		bodies = append(bodies, b)
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: b, Request: r}, nil
+	})
+	tr := &Transport{
+		Base: base,
+		NewWaiter: func() PerishableWaiter {
+			return checkingWaiter{PerishableWaiter: &MaxAttempts{N: 2, Waiter: fastWaiter()}, check: func() {
+				if bodies[len(bodies)-1].closed {
+					t.Fatal(`Closed a large body before a later attempt superseded it`)
+				}
+			}}
+		},
+	}
+	req, _ := http.NewRequest(http.MethodGet, `http://example.com/`, nil)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf(`Unexpected error: %v`, err)
+	}
+	defer resp.Body.Close()
+	if !bodies[0].closed {
+		t.Fatal(`Superseded response was not closed`)
+	}
+	if b, err := ioutil.ReadAll(resp.Body); err != nil || string(b) != payload {
+		t.Fatalf(`Expected the returned body in full, read %v bytes with %v`, len(b), err)
+	}
+}
+
+func TestTransportPropagatesContext(t *testing.T) {
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, `v`))
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Context().Value(key{}) != `v` {
+			t.Fatal(`Attempt did not run under the request context`)
+		}
+		cancel()
+		return nil, errors.New(`connection reset`)
+	})
+	req, _ := http.NewRequest(http.MethodGet, `http://example.com/`, nil)
+	_, err := (&Transport{Base: base, NewWaiter: fastWaiter}).RoundTrip(req.WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf(`Expected a cancellation error, got %v`, err)
+	}
+}
+
+func TestTransportIdempotency(t *testing.T) {
+	var keys []string
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		keys = append(keys, r.Header.Get(DefaultIdempotencyHeader))
+		return nil, errors.New(`connection reset`)
+	})
+	newWaiter := func() PerishableWaiter {
+		return &MaxAttempts{N: 3, Waiter: fastWaiter()}
+	}
+	send := func(tr *Transport, method string, header http.Header) {
+		keys = nil
+		req, _ := http.NewRequest(method, `http://example.com/`, strings.NewReader(`payload`))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if _, err := tr.RoundTrip(req); err == nil {
+			t.Fatal(`Expected an error`)
+		}
+		if req.Header.Get(DefaultIdempotencyHeader) != header.Get(DefaultIdempotencyHeader) {
+			t.Fatal(`RoundTrip modified the request headers`)
+		}
+	}
+
+	tr := &Transport{Base: base, NewWaiter: newWaiter}
+	send(tr, http.MethodPut, nil)
+	if len(keys) != 3 {
+		t.Fatalf(`Expected PUT to be retried, got %v attempts`, len(keys))
+	}
+	send(tr, http.MethodPost, nil)
+	if len(keys) != 1 {
+		t.Fatalf(`Expected POST to be sent once by default, got %v attempts`, len(keys))
+	}
+	send(tr, http.MethodPost, http.Header{DefaultIdempotencyHeader: []string{`caller-key`}})
+	if len(keys) != 3 || keys[2] != `caller-key` {
+		t.Fatalf(`Expected POST with a caller key to be retried with it, got %v`, keys)
+	}
+
+	tr.IdempotencyKeys = true
+	send(tr, http.MethodPatch, nil)
+	if len(keys) != 3 {
+		t.Fatalf(`Expected opted in PATCH to be retried, got %v attempts`, len(keys))
+	}
+	if keys[0] == `` || keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Fatalf(`Expected the same generated key on every attempt, got %v`, keys)
+	}
+	first := keys[0]
+	send(tr, http.MethodPatch, nil)
+	if keys[0] == first {
+		t.Fatal(`Reused an idempotency key across requests`)
+	}
+}
+
+func TestNewIdempotencyKey(t *testing.T) {
+	k := NewIdempotencyKey()
+	if len(k) != 36 || k[14] != '4' {
+		t.Fatalf(`Not a version 4 UUID: %v`, k)
+	}
+}
+
+func TestTransportAttemptTimeoutLargeBody(t *testing.T) {
+	payload := strings.Repeat(`x`, 4<<20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(payload))
+	}))
+	defer srv.Close()
+
+	p := Policy{TTL: time.Duration(30) * time.Second, AttemptTimeout: time.Duration(10) * time.Second}
+	client := &http.Client{Transport: &Transport{NewWaiter: p.NewWaiter}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf(`Unexpected error: %v`, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil || len(b) != len(payload) {
+		t.Fatalf(`Expected to read %v bytes, read %v with %v`, len(payload), len(b), err)
+	}
+}
+
+func TestTransportClosesBodyWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := &trackedBody{Reader: strings.NewReader(`payload`)}
+	req, _ := http.NewRequest(http.MethodPut, `http://example.com/`, body)
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(`payload`)), nil
+	}
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Fatal(`Sent a request that was already cancelled`)
+		return nil, nil
+	})
+	_, err := (&Transport{Base: base, NewWaiter: fastWaiter}).RoundTrip(req.WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf(`Expected a cancellation error, got %v`, err)
+	}
+	if !body.closed {
+		t.Fatal(`Request body was not closed`)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"context"
+	"time"
+)
+
+// MaxAttempts is a PerishableWaiter that gives up once N attempts have been made. When
+// Waiter is set the wait between attempts is delegated to it, so the retry loop stops on
+// whichever of the two gives up first. N of zero allows a single attempt.
+type MaxAttempts struct {
+	N       uint
+	Waiter  PerishableWaiter
+	made    uint
+	started bool
+}
+
+func (m *MaxAttempts) WaitOrDie(e error) error {
+	return m.WaitOrDieContext(context.Background(), e)
+}
+func (m *MaxAttempts) WaitOrDieContext(ctx context.Context, e error) error {
+	return m.waitPlanned(ctx, e, nil)
+}
+func (m *MaxAttempts) waitPlanned(ctx context.Context, e error, planned func(time.Duration)) error {
+	if _, ok := m.Waiter.(Scheduler); ok || m.Waiter == nil {
+		return waitScheduled(ctx, m, e, planned)
+	}
+	m.made++
+	if m.made >= m.N {
+		return e
+	}
+	return waitOrDie(ctx, m.Waiter, e, planned)
+}
+
+// Next plans the wait of the wrapped Waiter. If that Waiter is not a Scheduler, Next waits
+// on it directly.
+func (m *MaxAttempts) Next(e error) (time.Duration, error) {
+	m.made++
+	if m.made >= m.N {
+		return 0, e
+	}
+	switch w := m.Waiter.(type) {
+	case nil:
+		return 0, nil
+	case Scheduler:
+		return w.Next(e)
+	default:
+		return 0, w.WaitOrDie(e)
+	}
+}
+func (m *MaxAttempts) Deadline() (time.Time, bool) {
+	if s, ok := m.Waiter.(Scheduler); ok {
+		return s.Deadline()
+	}
+	return time.Time{}, false
+}
+func (m *MaxAttempts) Start() {
+	m.made = 0
+	m.started = true
+	if m.Waiter != nil {
+		m.Waiter.Start()
+	}
+}
+func (m *MaxAttempts) IsDying() bool {
+	return m.started
+}
+func (m *MaxAttempts) StopReason() StopReason {
+	if m.made >= m.N || m.Waiter == nil {
+		return StopExhausted
+	}
+	return stopReason(m.Waiter)
+}
+func (m *MaxAttempts) WaitClock() Clock {
+	if m.Waiter == nil {
+		return SystemClock
+	}
+	return ClockOf(m.Waiter)
+}
+func (m *MaxAttempts) AttemptDeadline() (time.Time, bool) {
+	if ad, ok := m.Waiter.(AttemptDeadliner); ok {
+		return ad.AttemptDeadline()
+	}
+	return time.Time{}, false
+}
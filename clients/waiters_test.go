@@ -0,0 +1,93 @@
+// Copyright 2017 Jeff Nickoloff "jeff@allingeek.com"
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxAttempts(t *testing.T) {
+	var pw PerishableWaiter
+	pw = &MaxAttempts{N: 3}
+	if pw.IsDying() {
+		t.Fatal(`Shouldn't be dying yet`)
+	}
+	pw.Start()
+	if !pw.IsDying() {
+		t.Fatal(`Should be dying by now`)
+	}
+	ie := errors.New(`e1`)
+	if e := pw.WaitOrDie(ie); e != nil {
+		t.Fatal(`Died after the first attempt`)
+	}
+	if e := pw.WaitOrDie(ie); e != nil {
+		t.Fatal(`Died after the second attempt`)
+	}
+	if e := pw.WaitOrDie(ie); e != ie {
+		t.Fatal(`Failed to die with the input error after the third attempt`)
+	}
+
+	// Start resets the attempt count
+	pw.Start()
+	if e := pw.WaitOrDie(ie); e != nil {
+		t.Fatal(`Start did not reset the attempt count`)
+	}
+}
+
+func TestMaxAttemptsDelegates(t *testing.T) {
+	waits := 0
+	inner := &JitteredBackoff{
+		TTL: time.Duration(1) * time.Second,
+		Bof: func(r uint, i time.Duration) time.Duration {
+			waits++
+			return time.Duration(0)
+		},
+		Jf: NoJitter,
+	}
+	pw := &MaxAttempts{N: 2, Waiter: inner}
+	pw.Start()
+	if !inner.IsDying() {
+		t.Fatal(`Start was not delegated`)
+	}
+	pw.WaitOrDie(nil)
+	pw.WaitOrDie(nil)
+	if waits != 1 {
+		t.Fatalf(`Expected 1 delegated wait, got %v`, waits)
+	}
+
+	// The inner waiter dying first also stops the retry loop
+	ie := errors.New(`e1`)
+	pw = &MaxAttempts{N: 100, Waiter: &JitteredBackoff{Bof: NoBackoff, Jf: NoJitter}}
+	pw.Start()
+	if e := pw.WaitOrDie(ie); e != ie {
+		t.Fatal(`Failed to die when the inner waiter expired`)
+	}
+}
+
+func TestRetryN(t *testing.T) {
+	attempts := 0
+	f := func() (interface{}, ClientError) {
+		attempts++
+		return nil, RetriableError{E: errors.New(`retriable`)}
+	}
+	if _, e := RetryPeriodicN(f, 4, time.Duration(1)*time.Minute, time.Duration(0), time.Duration(0)); e == nil {
+		t.Fatal(`No error was returned`)
+	}
+	if attempts != 4 {
+		t.Fatalf(`Expected 4 attempts, got %v`, attempts)
+	}
+}